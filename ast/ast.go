@@ -15,8 +15,9 @@ type Expr interface {
 
 type Assign struct {
 	Expr
-	Name  token.Token
-	Value Expr
+	Name    token.Token
+	Value   Expr
+	Declare bool // Written with ':=', which declares the variable in the current scope
 }
 
 type Binary struct {
@@ -34,6 +35,53 @@ type Call struct {
 	Arguments []Expr      // The arguments to the function
 }
 
+// Spread expression, for expanding an array into a call's arguments
+type Spread struct {
+	Expr
+	Operator   token.Token // The '...'
+	Expression Expr        // The array being spread
+}
+
+// Get expression, for accessing a property of an object
+type Get struct {
+	Expr
+	Object Expr        // The object whose property is being accessed
+	Name   token.Token // The name of the property
+}
+
+// Set expression, for assigning to a property of an object
+type Set struct {
+	Expr
+	Object Expr        // The object whose property is being assigned
+	Name   token.Token // The name of the property
+	Value  Expr        // The value being assigned
+}
+
+// List literal expression, for creating an array: [a, b, c]
+type ListLiteral struct {
+	Expr
+	Bracket  token.Token // The opening bracket
+	Elements []Expr
+}
+
+// Index expression, for reading an element of an array or a character of a
+// string: list[index]
+type Index struct {
+	Expr
+	Object  Expr        // The array or string being indexed
+	Bracket token.Token // The opening bracket
+	Index   Expr
+}
+
+// SetIndex expression, for assigning to an element of an array
+type SetIndex struct {
+	Expr
+	Object  Expr        // The array being indexed
+	Bracket token.Token // The opening bracket
+	Index   Expr
+	Value   Expr
+}
+
 type Grouping struct {
 	Expr
 	Expression Expr
@@ -51,6 +99,27 @@ type Logical struct {
 	Right    Expr
 }
 
+// Super expression, for looking up a method on the superclass
+type Super struct {
+	Expr
+	Keyword token.Token
+	Method  token.Token
+}
+
+// Ternary expression, for choosing between two values: condition ? then : else
+type Ternary struct {
+	Expr
+	Condition Expr
+	Then      Expr
+	Else      Expr
+}
+
+// This expression, for referring to the instance a method was called on
+type This struct {
+	Expr
+	Keyword token.Token
+}
+
 type Unary struct {
 	Expr
 	Operator token.Token
@@ -74,12 +143,38 @@ type Expression struct {
 	Expression Expr
 }
 
+// Defer statement, for running an expression when the enclosing function returns
+type Defer struct {
+	Stmt
+	Keyword    token.Token
+	Expression Expr
+}
+
+// Class statement, for declaring a class and its methods
+type Class struct {
+	Stmt
+	Name       token.Token
+	Superclass *Variable // The class inherited from, or nil
+	Methods    []*Function
+}
+
+// Enum statement, for declaring an enumeration of named members
+type Enum struct {
+	Stmt
+	Name    token.Token
+	Members []token.Token
+}
+
 // Function statement, for declaring a function
 type Function struct {
 	Stmt
-	Name       token.Token
-	Parameters []token.Token
-	Body       []Stmt
+	Name           token.Token
+	Parameters     []token.Token
+	ParameterTypes []string // Annotated type of each parameter, "any" if none was given
+	ReturnType     string   // Annotated return type, "any" if none was given
+	Guard          Expr     // Optional `where` clause checked before the body runs
+	GuardText      string   // Source text of the guard, for error messages
+	Body           []Stmt
 }
 
 type Block struct {
@@ -94,8 +189,18 @@ type If struct {
 	Else      Stmt
 }
 
+// Import statement, for running another script. If an alias is given, the
+// script's top-level definitions are exposed as properties of a module value.
+type Import struct {
+	Stmt
+	Keyword token.Token
+	Path    token.Token
+	Alias   *token.Token
+}
+
 type Print struct {
 	Expr
+	Keyword    token.Token
 	Expression Expr
 }
 
@@ -109,18 +214,48 @@ type Return struct {
 type Var struct {
 	Stmt
 	Name        token.Token
+	Type        string // Annotated type, "any" if none was given
+	Initializer Expr
+	Constant    bool // Declared with const, so it can't be reassigned
+	Lazy        bool // Declared with lazy const, so the initializer runs on first read
+}
+
+// With statement, for binding a resource whose close method runs when the
+// body finishes, however it finishes
+type With struct {
+	Stmt
+	Keyword     token.Token
+	Name        token.Token
 	Initializer Expr
+	Body        Stmt
 }
 
 type While struct {
 	Stmt
+	Keyword   token.Token // The 'while' or 'for' keyword the loop came from
 	Condition Expr
 	Body      Stmt
+	Increment Expr // Evaluated after each iteration of a for loop, or nil
+}
+
+// Break statement, for leaving the innermost loop
+type Break struct {
+	Stmt
+	Keyword token.Token
+}
+
+// Continue statement, for skipping to the next iteration of the innermost loop
+type Continue struct {
+	Stmt
+	Keyword token.Token
 }
 
 /* Printers */
 
 func (a *Assign) String() string {
+	if a.Declare {
+		return fmt.Sprintf("%s := %s", a.Name.Lexeme, a.Value.String())
+	}
 	return fmt.Sprintf("%s = %s", a.Name.Lexeme, a.Value.String())
 }
 
@@ -140,6 +275,18 @@ func (u *Unary) String() string {
 	return fmt.Sprintf("(%v %v)", u.Operator.Lexeme, u.Right.String())
 }
 
+func (t *Ternary) String() string {
+	return fmt.Sprintf("(?: %v %v %v)", t.Condition.String(), t.Then.String(), t.Else.String())
+}
+
+func (s *Super) String() string {
+	return fmt.Sprintf("(super %v)", s.Method.Lexeme)
+}
+
+func (t *This) String() string {
+	return "this"
+}
+
 func (v *Variable) String() string {
 	return fmt.Sprintf("%v", v.Name.Lexeme)
 }
@@ -153,17 +300,34 @@ func (p *Print) String() string {
 }
 
 func (v *Var) String() string {
+	keyword := "var"
+	if v.Lazy {
+		keyword = "lazy const"
+	} else if v.Constant {
+		keyword = "const"
+	}
 	if v.Initializer != nil {
-		return fmt.Sprintf("(var %v = %v)", v.Name.Lexeme, v.Initializer.String())
+		return fmt.Sprintf("(%v %v = %v)", keyword, v.Name.Lexeme, v.Initializer.String())
 	} else {
-		return fmt.Sprintf("(var %v)", v.Name.Lexeme)
+		return fmt.Sprintf("(%v %v)", keyword, v.Name.Lexeme)
 	}
 }
 
 func (w *While) String() string {
+	if w.Increment != nil {
+		return fmt.Sprintf("(while %v %v %v)", w.Condition.String(), w.Body.String(), w.Increment.String())
+	}
 	return fmt.Sprintf("(while %v %v)", w.Condition.String(), w.Body.String())
 }
 
+func (b *Break) String() string {
+	return "(break)"
+}
+
+func (c *Continue) String() string {
+	return "(continue)"
+}
+
 func (i *If) String() string {
 	if i.Else != nil {
 		return fmt.Sprintf("(if %v %v %v)", i.Condition.String(), i.Then.String(), i.Else.String())
@@ -187,3 +351,66 @@ func (f *Function) String() string {
 func (c *Call) String() string {
 	return fmt.Sprintf("(call %v %v)", c.Callee.String(), c.Arguments)
 }
+
+func (s *Spread) String() string {
+	return fmt.Sprintf("...%v", s.Expression.String())
+}
+
+func (g *Get) String() string {
+	return fmt.Sprintf("(get %v %v)", g.Object.String(), g.Name.Lexeme)
+}
+
+func (l *ListLiteral) String() string {
+	return fmt.Sprintf("(list %v)", l.Elements)
+}
+
+func (x *Index) String() string {
+	return fmt.Sprintf("(index %v %v)", x.Object.String(), x.Index.String())
+}
+
+func (s *SetIndex) String() string {
+	return fmt.Sprintf("(set-index %v %v %v)", s.Object.String(), s.Index.String(), s.Value.String())
+}
+
+func (s *Set) String() string {
+	return fmt.Sprintf("(set %v %v %v)", s.Object.String(), s.Name.Lexeme, s.Value.String())
+}
+
+func (c *Class) String() string {
+	if c.Superclass != nil {
+		return fmt.Sprintf("(class %v < %v %v)", c.Name.Lexeme, c.Superclass.Name.Lexeme, c.Methods)
+	}
+	return fmt.Sprintf("(class %v %v)", c.Name.Lexeme, c.Methods)
+}
+
+func (r *Return) String() string {
+	if r.Value != nil {
+		return fmt.Sprintf("(return %v)", r.Value.String())
+	} else {
+		return "(return)"
+	}
+}
+
+func (w *With) String() string {
+	return fmt.Sprintf("(with %v = %v %v)", w.Name.Lexeme, w.Initializer.String(), w.Body.String())
+}
+
+func (d *Defer) String() string {
+	return fmt.Sprintf("(defer %v)", d.Expression.String())
+}
+
+func (e *Enum) String() string {
+	members := make([]string, len(e.Members))
+	for i, member := range e.Members {
+		members[i] = member.Lexeme
+	}
+	return fmt.Sprintf("(enum %v %v)", e.Name.Lexeme, members)
+}
+
+func (i *Import) String() string {
+	if i.Alias != nil {
+		return fmt.Sprintf("(import %v as %v)", i.Path.Lexeme, i.Alias.Lexeme)
+	} else {
+		return fmt.Sprintf("(import %v)", i.Path.Lexeme)
+	}
+}
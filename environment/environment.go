@@ -8,8 +8,24 @@ import (
 type Environment struct {
 	Enclosing *Environment
 	Values    map[string]any
+	// Annotated types of the variables declared here, if any
+	types map[string]string
+	// Names declared here with const, which can't be assigned to
+	constants map[string]bool
 }
 
+// Lazy is the value of a lazy constant that hasn't been read yet. The first
+// Get runs Evaluate and replaces the Lazy with its result.
+type Lazy struct {
+	Evaluate   func() (any, error)
+	evaluating bool
+}
+
+// uninitialized is the value of a variable declared without an initializer,
+// which is an error to read. It's distinct from nil, which is a value like
+// any other.
+var uninitialized = &struct{}{}
+
 func New() *Environment {
 	return &Environment{
 		Values: make(map[string]any),
@@ -22,15 +38,86 @@ func NewEnclosed(enclosing *Environment) *Environment {
 	return env
 }
 
+// Define declares a variable, replacing any variable or constant of the same
+// name declared here along with its type annotation.
 func (e *Environment) Define(name string, value any) {
 	e.Values[name] = value
+	delete(e.types, name)
+	delete(e.constants, name)
+}
+
+// DefineUninitialized declares a variable that has no value until it is
+// assigned one.
+func (e *Environment) DefineUninitialized(name string) {
+	e.Values[name] = uninitialized
+	delete(e.types, name)
+	delete(e.constants, name)
+}
+
+// SetType records the annotated type of a variable declared in this
+// environment.
+func (e *Environment) SetType(name string, typeName string) {
+	if e.types == nil {
+		e.types = make(map[string]string)
+	}
+	e.types[name] = typeName
+}
+
+// TypeOf returns the annotated type of the variable, looking in the
+// environment that declares it. Unannotated variables have type "any".
+func (e *Environment) TypeOf(name string) string {
+	if _, ok := e.Values[name]; ok {
+		if typeName, ok := e.types[name]; ok {
+			return typeName
+		}
+		return "any"
+	}
+	if e.Enclosing != nil {
+		return e.Enclosing.TypeOf(name)
+	}
+	return "any"
+}
+
+// DefineConstant declares a name that can't be assigned to afterwards.
+func (e *Environment) DefineConstant(name string, value any) {
+	if e.constants == nil {
+		e.constants = make(map[string]bool)
+	}
+	e.constants[name] = true
+	e.Values[name] = value
+	delete(e.types, name)
+}
+
+// Declare defines a variable in this environment, replacing any variable of
+// the same name already declared here. Constants can't be replaced.
+func (e *Environment) Declare(name token.Token, value any) error {
+	if e.constants[name.Lexeme] {
+		return logger.InterpreterErrorWithLineNumber(name, "Cannot assign to constant '"+name.Lexeme+"'.")
+	}
+	e.Values[name.Lexeme] = value
+	delete(e.types, name.Lexeme)
+	return nil
 }
 
 func (e *Environment) Get(name token.Token) (any, error) {
 	// First, check the current environment
 	if value, ok := e.Values[name.Lexeme]; ok {
-		// If the variable is set to nil, it hasn't been initialized yet - this is a runtime error
-		if value == nil {
+		// Compute a lazy constant on first use and keep the result
+		if lazy, ok := value.(*Lazy); ok {
+			if lazy.evaluating {
+				return nil, logger.InterpreterErrorWithLineNumber(name, "Lazy constant '"+name.Lexeme+"' depends on itself.")
+			}
+			lazy.evaluating = true
+			computed, err := lazy.Evaluate()
+			lazy.evaluating = false
+			if err != nil {
+				return nil, err
+			}
+			e.Values[name.Lexeme] = computed
+			value = computed
+		}
+		// Reading a variable before it has been given a value is a runtime error
+		if value == uninitialized {
 			return nil, logger.InterpreterErrorWithLineNumber(name, "Variable '"+name.Lexeme+"' used before being initialized.")
 		}
 		return value, nil
@@ -46,6 +133,9 @@ func (e *Environment) Get(name token.Token) (any, error) {
 func (e *Environment) Assign(name token.Token, value any) (any, error) {
 	// If current environment contains the variable, assign it
 	if _, ok := e.Values[name.Lexeme]; ok {
+		if e.constants[name.Lexeme] {
+			return nil, logger.InterpreterErrorWithLineNumber(name, "Cannot assign to constant '"+name.Lexeme+"'.")
+		}
 		e.Values[name.Lexeme] = value
 		return value, nil
 	}
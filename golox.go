@@ -3,17 +3,25 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 
+	"github.com/lowercasename/golox/ast"
 	"github.com/lowercasename/golox/interpreter"
 	"github.com/lowercasename/golox/parser"
+	"github.com/lowercasename/golox/resolver"
 	"github.com/lowercasename/golox/scanner"
+	"github.com/lowercasename/golox/token"
 	"github.com/pkg/term"
 )
 
 const (
 	version = "0.1.0"
+	usage   = "Usage: golox [--debug] [--fun] [--continue-on-error] [--big-numbers] [--warn-shadow] [--warn-unused] [--dump-ast] [--tokens] [script [arguments...]]\nEverything after the script, including flags, is passed on to it."
 )
 
 // Raw input keycodes
@@ -27,6 +35,7 @@ var delete byte = 127
 var backspace byte = 8
 var ctrlC byte = 3
 var ctrlD byte = 4
+var tab byte = 9
 var keys = map[byte]bool{
 	up:    true,
 	down:  true,
@@ -34,26 +43,257 @@ var keys = map[byte]bool{
 	left:  true,
 }
 
-func runFile(path string, debug bool) error {
-	bytes, err := os.ReadFile(path)
+// wordBeforeCursor returns the identifier characters immediately before
+// position in input, and the index at which that word starts.
+func wordBeforeCursor(input string, position int) (string, int) {
+	start := position
+	for start > 0 {
+		c := input[start-1]
+		if !(c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')) {
+			break
+		}
+		start--
+	}
+	return input[start:position], start
+}
+
+// completionCandidates returns the sorted, de-duplicated names that start
+// with prefix.
+func completionCandidates(names []string, prefix string) []string {
+	seen := map[string]bool{}
+	candidates := []string{}
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) && !seen[name] {
+			seen[name] = true
+			candidates = append(candidates, name)
+		}
+	}
+	sort.Strings(candidates)
+	return candidates
+}
+
+// replAST remembers the statements parsed from the most recent REPL input so
+// they can be shown with the :ast command.
+type replAST struct {
+	statements []ast.Expr
+}
+
+func (r *replAST) record(statements []ast.Expr) {
+	r.statements = statements
+}
+
+// render pretty-prints the recorded statements, one per line
+func (r *replAST) render() string {
+	if len(r.statements) == 0 {
+		return "No AST to show.\n"
+	}
+	var builder strings.Builder
+	for _, statement := range r.statements {
+		builder.WriteString(statement.String() + "\n")
+	}
+	return builder.String()
+}
+
+// options holds the flags passed on the command line
+type options struct {
+	debug           bool
+	fun             bool
+	continueOnError bool
+	bigNumbers      bool
+	warnShadow      bool
+	warnUnused      bool
+	// Print the statements of the script instead of running it
+	dumpAST bool
+	// Print the tokens of the script instead of running it
+	dumpTokens bool
+	// Arguments following the script path, passed on to the script
+	args []string
+}
+
+// parseArgs splits the command-line arguments into flags, an optional script
+// path and the arguments for the script. Flags are only recognised before the
+// script path. It returns false if the arguments are not valid.
+func parseArgs(args []string) (options, string, bool) {
+	opts := options{}
+	script := ""
+	for _, arg := range args {
+		switch {
+		case script != "":
+			opts.args = append(opts.args, arg)
+		case arg == "--debug":
+			opts.debug = true
+		case arg == "--fun":
+			opts.fun = true
+		case arg == "--continue-on-error":
+			opts.continueOnError = true
+		case arg == "--big-numbers":
+			opts.bigNumbers = true
+		case arg == "--warn-shadow":
+			opts.warnShadow = true
+		case arg == "--warn-unused":
+			opts.warnUnused = true
+		case arg == "--dump-ast":
+			opts.dumpAST = true
+		case arg == "--tokens":
+			opts.dumpTokens = true
+		case strings.HasPrefix(arg, "--"):
+			return opts, "", false
+		default:
+			script = arg
+		}
+	}
+	return opts, script, true
+}
+
+// newInterpreter creates an interpreter configured by the command-line flags
+func newInterpreter(opts options) *interpreter.Interpreter {
+	i := interpreter.New()
+	if opts.fun {
+		i.Messages = interpreter.FunMessages
+	}
+	i.ContinueOnError = opts.continueOnError
+	i.BigNumbers = opts.bigNumbers
+	i.SetArgs(opts.args)
+	return i
+}
+
+// runFile runs a script, or the script piped to stdin if path is empty,
+// returning the exit status for the process
+func runFile(path string, opts options) (int, error) {
+	source, err := readScript(path)
 	if err != nil {
-		return err
+		return 1, err
+	}
+	interpreter := newInterpreter(opts)
+	if path != "" {
+		interpreter.ScriptDir = filepath.Dir(path)
 	}
-	interpreter := interpreter.New()
-	run(string(bytes), interpreter, debug)
-	return nil
+	// A script that doesn't compile exits like --dump-ast does on it.
+	if _, ok := run(source, interpreter, opts); !ok {
+		return 65, nil
+	}
+	if len(interpreter.Errors()) > 0 {
+		return 70, nil
+	}
+	return interpreter.ExitCode(), nil
 }
 
-func runPrompt(debug bool) {
-	scanner := bufio.NewScanner(os.Stdin)
-	interpreter := interpreter.New()
-	fmt.Print("> ")
+// readScript reads the script at path, or standard input if path is empty.
+func readScript(path string) (string, error) {
+	var bytes []byte
+	var err error
+	if path == "" {
+		bytes, err = io.ReadAll(os.Stdin)
+	} else {
+		bytes, err = os.ReadFile(path)
+	}
+	return string(bytes), err
+}
+
+// stdinPiped reports whether standard input comes from a pipe or file rather
+// than a terminal.
+func stdinPiped() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice == 0
+}
+
+// dumpTokens scans source, printing each token to out and any errors to
+// errOut. It returns 65 if there were errors.
+func dumpTokens(source string, out, errOut io.Writer) int {
+	scanner := scanner.New(source)
+	for _, t := range scanner.ScanTokens() {
+		fmt.Fprintln(out, t.String())
+	}
+	for _, err := range scanner.Errors() {
+		fmt.Fprint(errOut, err)
+	}
+	if len(scanner.Errors()) > 0 {
+		return 65
+	}
+	return 0
+}
+
+// dumpAST scans and parses source, printing each statement to out and any
+// errors to errOut. It returns 65 if there were errors, like a compiler
+// rejecting its input.
+func dumpAST(source string, out, errOut io.Writer) int {
+	scanner := scanner.New(source)
+	tokens := scanner.ScanTokens()
+	parser := parser.New(tokens)
+	statements, errors := parser.Parse()
+	for _, statement := range statements {
+		fmt.Fprintln(out, statement.String())
+	}
+	for _, err := range scanner.Errors() {
+		fmt.Fprint(errOut, err)
+	}
+	for _, err := range errors {
+		fmt.Fprint(errOut, err)
+	}
+	if len(scanner.Errors()) > 0 || len(errors) > 0 {
+		return 65
+	}
+	return 0
+}
+
+func runPrompt(opts options) {
+	prompt(os.Stdin, newInterpreter(opts), opts)
+}
+
+// prompt reads lines from input and runs each statement once it is
+// complete, so a block can be typed over several lines. Prompts are written
+// to the interpreter's output.
+func prompt(input io.Reader, interpreter *interpreter.Interpreter, opts options) {
+	scanner := bufio.NewScanner(input)
+	source := ""
+	fmt.Fprint(interpreter.Out, "> ")
 	for scanner.Scan() {
-		run(scanner.Text(), interpreter, debug)
-		fmt.Print("> ")
+		source += scanner.Text() + "\n"
+		if incomplete(source) {
+			fmt.Fprint(interpreter.Out, "... ")
+			continue
+		}
+		run(source, interpreter, opts)
+		source = ""
+		fmt.Fprint(interpreter.Out, "> ")
+	}
+	// Run whatever was left when the input ended, so its errors are shown.
+	if strings.TrimSpace(source) != "" {
+		run(source, interpreter, opts)
 	}
 }
 
+// incomplete reports whether source stops partway through a statement,
+// either with brackets left open or with the parser reaching the end of the
+// input while it still expected more.
+func incomplete(source string) bool {
+	scanner := scanner.New(source)
+	tokens := scanner.ScanTokens()
+	depth := 0
+	for _, t := range tokens {
+		switch t.Type {
+		case token.LEFT_PAREN, token.LEFT_BRACE, token.LEFT_BRACKET:
+			depth++
+		case token.RIGHT_PAREN, token.RIGHT_BRACE, token.RIGHT_BRACKET:
+			depth--
+		}
+	}
+	if depth > 0 {
+		return true
+	}
+	if len(scanner.Errors()) > 0 {
+		return false
+	}
+	parser := parser.New(tokens)
+	_, errors := parser.Parse()
+	for _, err := range errors {
+		if strings.Contains(err.Error(), "Error at end:") {
+			return true
+		}
+	}
+	return false
+}
+
 // getInput will read raw input from the terminal
 // It returns the raw ASCII value inputted
 // From: https://github.com/Nexidian/gocliselect
@@ -87,13 +327,18 @@ func getInput() byte {
 	return 0
 }
 
-func runRawPrompt(debug bool) string {
+func runRawPrompt(opts options) string {
 	fmt.Println("Welcome to Golox " + version + "!")
 	fmt.Println("Press Ctrl+C or Ctrl+D to exit.")
 	// Print the prompt
 	fmt.Print("> ")
-	interpreter := interpreter.New()
+	interpreter := newInterpreter(opts)
 	currentInput := ""
+	// Lines of a statement that continues onto the next line
+	pending := ""
+	currentPrompt := "> "
+	// Remember the last parsed input for the :ast command
+	lastAST := replAST{}
 	// Set up a command history
 	history := []string{}
 	// Set up a pointer to the current command in the history
@@ -113,7 +358,7 @@ func runRawPrompt(debug bool) string {
 				// Erase the current line
 				fmt.Print("\033[2K\r")
 				// Print the current input
-				fmt.Print("\r> " + currentInput)
+				fmt.Print("\r" + currentPrompt + currentInput)
 				// Move the cursor back to the current position
 				for i := 0; i < len(currentInput)-positionPointer; i++ {
 					fmt.Print("\033[1D")
@@ -123,11 +368,21 @@ func runRawPrompt(debug bool) string {
 			// Print a newline to the terminal
 			fmt.Print("\n")
 			// DEBUG: Print the current input
-			if debug {
+			if opts.debug {
 				fmt.Println("DEBUG: " + currentInput)
 			}
-			// Send input to interpreter
-			run(currentInput, interpreter, debug)
+			if pending == "" && currentInput == ":ast" {
+				// Show the AST of the last input without re-running it
+				fmt.Print(lastAST.render())
+			} else if incomplete(pending + currentInput + "\n") {
+				// Keep reading until the statement is finished
+				pending += currentInput + "\n"
+			} else {
+				// Send input to interpreter
+				statements, _ := run(pending+currentInput, interpreter, opts)
+				lastAST.record(statements)
+				pending = ""
+			}
 			// Add input to history
 			history = append(history, currentInput)
 			// Reset the history pointer
@@ -136,8 +391,12 @@ func runRawPrompt(debug bool) string {
 			positionPointer = 0
 			// Clear the current input
 			currentInput = ""
-			// Print the prompt
-			fmt.Print("\r> ")
+			// Print the prompt, or the continuation prompt for an unfinished statement
+			currentPrompt = "> "
+			if pending != "" {
+				currentPrompt = "... "
+			}
+			fmt.Print("\r" + currentPrompt)
 		} else if keyCode == up {
 			// If the history pointer is not at the beginning of the history
 			if historyPointer > 0 {
@@ -148,7 +407,7 @@ func runRawPrompt(debug bool) string {
 					fmt.Print("\b \b")
 				}
 				// Print the prompt
-				fmt.Print("\r> ")
+				fmt.Print("\r" + currentPrompt)
 				// Print the command fetched from the history
 				fmt.Print(history[historyPointer])
 				// Set the current input to the command fetched from the history
@@ -166,7 +425,7 @@ func runRawPrompt(debug bool) string {
 					fmt.Print("\b \b")
 				}
 				// Print the prompt
-				fmt.Print("\r> ")
+				fmt.Print("\r" + currentPrompt)
 				// Print the command fetched from the history
 				fmt.Print(history[historyPointer])
 				// Set the current input to the command fetched from the history
@@ -180,7 +439,7 @@ func runRawPrompt(debug bool) string {
 					fmt.Print("\b \b")
 				}
 				// Print the prompt
-				fmt.Print("\r> ")
+				fmt.Print("\r" + currentPrompt)
 				// Reset the current input
 				currentInput = ""
 				// Reset the position pointer
@@ -204,6 +463,30 @@ func runRawPrompt(debug bool) string {
 				// Move the cursor forward
 				fmt.Print("\033[1C")
 			}
+		} else if keyCode == tab {
+			// Complete the word under the cursor against defined names and keywords
+			word, _ := wordBeforeCursor(currentInput, positionPointer)
+			if word == "" {
+				continue
+			}
+			candidates := completionCandidates(append(interpreter.GlobalNames(), scanner.Keywords()...), word)
+			if len(candidates) == 1 {
+				// Insert the rest of the only match at the current position
+				completion := candidates[0][len(word):]
+				currentInput = currentInput[:positionPointer] + completion + currentInput[positionPointer:]
+				positionPointer += len(completion)
+			} else if len(candidates) > 1 {
+				// Show the possible matches below the prompt
+				fmt.Print("\n" + strings.Join(candidates, "  ") + "\n")
+			}
+			// Erase the current line
+			fmt.Print("\033[2K\r")
+			// Print the current input
+			fmt.Print("\r" + currentPrompt + currentInput)
+			// Move the cursor back to the current position
+			for i := 0; i < len(currentInput)-positionPointer; i++ {
+				fmt.Print("\033[1D")
+			}
 		} else if keyCode >= 32 && keyCode <= 126 { // Printable ASCII characters
 			// Insert the character at the current position
 			currentInput = currentInput[:positionPointer] + string(keyCode) + currentInput[positionPointer:]
@@ -211,7 +494,7 @@ func runRawPrompt(debug bool) string {
 			// Erase the current line
 			fmt.Print("\033[2K\r")
 			// Print the current input
-			fmt.Print("\r> " + currentInput)
+			fmt.Print("\r" + currentPrompt + currentInput)
 			// Move the cursor back to the current position
 			for i := 0; i < len(currentInput)-positionPointer; i++ {
 				fmt.Print("\033[1D")
@@ -220,10 +503,16 @@ func runRawPrompt(debug bool) string {
 	}
 }
 
-func run(source string, interpreter *interpreter.Interpreter, debug bool) {
+// run scans, parses, resolves and interprets source, returning the parsed
+// statements and whether it got as far as interpreting them. It returns
+// false when scanning, parsing or resolving reported an error.
+func run(source string, interpreter *interpreter.Interpreter, opts options) ([]ast.Expr, bool) {
 	scanner := scanner.New(source)
 	tokens := scanner.ScanTokens()
-	if debug {
+	for _, err := range scanner.Errors() {
+		fmt.Fprint(interpreter.Err, err)
+	}
+	if opts.debug {
 		fmt.Println("==================")
 		fmt.Println("Tokens:")
 		for _, token := range tokens {
@@ -232,8 +521,11 @@ func run(source string, interpreter *interpreter.Interpreter, debug bool) {
 		fmt.Println("==================")
 	}
 	parser := parser.New(tokens)
-	statements := parser.Parse()
-	if debug {
+	statements, errors := parser.Parse()
+	for _, err := range errors {
+		fmt.Fprint(interpreter.Err, err)
+	}
+	if opts.debug {
 		fmt.Println("==================")
 		fmt.Println("Statements:")
 		for _, statement := range statements {
@@ -241,33 +533,59 @@ func run(source string, interpreter *interpreter.Interpreter, debug bool) {
 		}
 		fmt.Println("==================")
 	}
+	// Don't run a program that didn't scan or parse cleanly.
+	if len(scanner.Errors()) > 0 || len(errors) > 0 {
+		return statements, false
+	}
+	resolver := resolver.New()
+	resolver.WarnShadow = opts.warnShadow
+	resolver.WarnUnreachable = true
+	resolver.WarnUnused = opts.warnUnused
+	for _, warning := range resolver.Resolve(statements) {
+		fmt.Fprint(interpreter.Err, warning)
+	}
+	if resolver.HadError() {
+		return statements, false
+	}
+	// With --continue-on-error there may be several errors to report.
+	reported := len(interpreter.Errors())
 	interpreter.Interpret(statements)
-	return
+	for _, err := range interpreter.Errors()[reported:] {
+		fmt.Fprint(interpreter.Err, err)
+	}
+	return statements, true
 }
 
 func main() {
-	args := os.Args[1:]
-	argsCount := len(args)
-
-	switch {
-	case argsCount > 2:
-		fmt.Println("Usage: golox [script] [--debug]")
-	case argsCount == 1:
-		if args[0] == "--debug" {
-			runRawPrompt(true)
-		} else {
-			err := runFile(args[0], false)
-			if err != nil {
-				fmt.Println(err)
-			}
+	opts, script, ok := parseArgs(os.Args[1:])
+	if !ok {
+		fmt.Println(usage)
+		return
+	}
+	if opts.dumpAST || opts.dumpTokens {
+		// Without a script, dump whatever is piped in.
+		if script == "" && !stdinPiped() {
+			fmt.Println(usage)
+			return
+		}
+		source, err := readScript(script)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
 		}
-	case argsCount == 2:
-		if args[1] == "--debug" {
-			runFile(args[0], true)
-		} else {
-			fmt.Println("Usage: golox [script] [--debug]")
+		if opts.dumpTokens {
+			os.Exit(dumpTokens(source, os.Stdout, os.Stderr))
 		}
-	default:
-		runRawPrompt(false)
+		os.Exit(dumpAST(source, os.Stdout, os.Stderr))
+	}
+	// Only start the REPL for a terminal; otherwise run what's piped in.
+	if script == "" && !stdinPiped() {
+		runRawPrompt(opts)
+		return
+	}
+	status, err := runFile(script, opts)
+	if err != nil {
+		fmt.Println(err)
 	}
+	os.Exit(status)
 }
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lowercasename/golox/parser"
+	"github.com/lowercasename/golox/scanner"
+)
+
+func TestReplAST(t *testing.T) {
+	lastAST := replAST{}
+	if lastAST.render() != "No AST to show.\n" {
+		t.Fatalf("expected=No AST to show., got=%q", lastAST.render())
+	}
+	scanner := scanner.New("var a = 1 + 2; print a;")
+	parser := parser.New(scanner.ScanTokens())
+	statements, _ := parser.Parse()
+	lastAST.record(statements)
+	expected := "(var a = (+ '1' '2'))\n(print a)\n"
+	if lastAST.render() != expected {
+		t.Fatalf("expected=%q, got=%q", expected, lastAST.render())
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	opts, script, ok := parseArgs([]string{"--fun", "test.lox"})
+	if !ok || script != "test.lox" || !opts.fun || opts.debug {
+		t.Fatalf("expected=test.lox with --fun, got=%v %q %v", opts, script, ok)
+	}
+	opts, script, ok = parseArgs([]string{"--debug"})
+	if !ok || script != "" || !opts.debug {
+		t.Fatalf("expected=--debug without a script, got=%v %q %v", opts, script, ok)
+	}
+	if _, _, ok = parseArgs([]string{"--nope"}); ok {
+		t.Fatalf("expected unknown flag to be rejected")
+	}
+	// Golox's own flags after the script are passed on like any other argument
+	opts, script, ok = parseArgs([]string{"a.lox", "b.lox", "--nope", "--debug"})
+	if !ok || script != "a.lox" || opts.debug || strings.Join(opts.args, " ") != "b.lox --nope --debug" {
+		t.Fatalf("expected arguments after the script to be passed on, got=%v %q %v", opts, script, ok)
+	}
+}
+
+func TestScriptArguments(t *testing.T) {
+	var out bytes.Buffer
+	opts, _, _ := parseArgs([]string{"args.lox", "one", "two"})
+	interpreter := newInterpreter(opts)
+	interpreter.Out = &out
+	run("print argv;", interpreter, opts)
+	if out.String() != "[one, two]\n" {
+		t.Fatalf("expected=%q, got=%q", "[one, two]\n", out.String())
+	}
+}
+
+func TestSetExitCode(t *testing.T) {
+	var out bytes.Buffer
+	interpreter := newInterpreter(options{})
+	interpreter.Out = &out
+	run("setExitCode(3);\nprint \"still running\";", interpreter, options{})
+	if out.String() != "still running\n" || interpreter.ExitCode() != 3 {
+		t.Fatalf("expected the script to finish with status 3, got=%q %d", out.String(), interpreter.ExitCode())
+	}
+
+	path := filepath.Join(t.TempDir(), "exit.lox")
+	if err := os.WriteFile(path, []byte("setExitCode(3);\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	status, err := runFile(path, options{})
+	if err != nil || status != 3 {
+		t.Fatalf("expected=3, got=%d (%v)", status, err)
+	}
+	// A runtime error overrides the requested status.
+	if err := os.WriteFile(path, []byte("setExitCode(3);\n1 / 0;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	status, _ = runFile(path, options{})
+	if status != 70 {
+		t.Fatalf("expected=70, got=%d", status)
+	}
+	// A script that fails to scan, parse or resolve isn't run at all.
+	for _, source := range []string{"setExitCode(3);\n#\n", "setExitCode(3);\nprint ;\n", "setExitCode(3);\n{ var a = a; }\n"} {
+		if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+		status, _ = runFile(path, options{})
+		if status != 65 {
+			t.Fatalf("expected=65 for %q, got=%d", source, status)
+		}
+	}
+}
+
+func TestRunFileRelativeImport(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "sub")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		filepath.Join(dir, "lib.lox"):  "var code = 7;\n",
+		filepath.Join(dir, "main.lox"): "import \"lib.lox\" as l;\nsetExitCode(l.code);\n",
+	}
+	for path, source := range files {
+		if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The import is found next to the script, wherever golox is run from.
+	status, err := runFile(filepath.Join(dir, "main.lox"), options{})
+	if err != nil || status != 7 {
+		t.Fatalf("expected=7, got=%d (%v)", status, err)
+	}
+}
+
+func TestRunReportsErrors(t *testing.T) {
+	opts := options{continueOnError: true}
+	var out, errOut bytes.Buffer
+	interpreter := newInterpreter(opts)
+	interpreter.Out, interpreter.Err = &out, &errOut
+	run("1 / 0;\nprint \"next\";\n-\"a\";", interpreter, opts)
+	expected := "[line 1] RuntimeError at '/': Division by zero.\n[line 3] RuntimeError at '-': Operand must be a number.\n"
+	if out.String() != "next\n" || errOut.String() != expected {
+		t.Fatalf("expected=%q, got=%q %q", expected, out.String(), errOut.String())
+	}
+	// Scanner and parser errors are reported alongside runtime errors, and
+	// stop the program from running at all.
+	out.Reset()
+	errOut.Reset()
+	run("print \"ok\";\n#", interpreter, opts)
+	if out.Len() != 0 || errOut.String() != "[line 2] ScannerError: Unexpected character.\n" {
+		t.Fatalf("expected a scanner error, got=%q %q", out.String(), errOut.String())
+	}
+	errOut.Reset()
+	run("print \"ok\";\nprint ;", interpreter, opts)
+	if out.Len() != 0 || errOut.String() != "[line 2] ParserError at ';': Expected expression.\n" {
+		t.Fatalf("expected a parser error, got=%q %q", out.String(), errOut.String())
+	}
+	// Errors from earlier runs aren't reported again.
+	errOut.Reset()
+	run("print \"fine\";", interpreter, opts)
+	if errOut.Len() != 0 {
+		t.Fatalf("expected no errors, got=%q", errOut.String())
+	}
+}
+
+func TestPromptMultiline(t *testing.T) {
+	var out, errOut bytes.Buffer
+	interpreter := newInterpreter(options{})
+	interpreter.Out, interpreter.Err = &out, &errOut
+	prompt(strings.NewReader("if (true) {\n  print 1;\n}\nprint 2;\n"), interpreter, options{})
+	expected := "> ... ... 1\n> 2\n> "
+	if out.String() != expected || errOut.Len() != 0 {
+		t.Fatalf("expected=%q, got=%q %q", expected, out.String(), errOut.String())
+	}
+
+	tests := map[string]bool{
+		"if (true) {\n":                true,
+		"fun f(a,\n":                   true,
+		"var a = [1,\n":                true,
+		"print 1\n":                    true,
+		"if (true) {\n  print 1;\n}\n": false,
+		"print 1;\n":                   false,
+		"print ;\n":                    false,
+		"}\n":                          false,
+	}
+	for source, expected := range tests {
+		if incomplete(source) != expected {
+			t.Fatalf("expected incomplete(%q)=%v", source, expected)
+		}
+	}
+}
+
+func TestDumpAST(t *testing.T) {
+	opts, script, ok := parseArgs([]string{"--dump-ast", "test.lox"})
+	if !ok || !opts.dumpAST || script != "test.lox" {
+		t.Fatalf("expected --dump-ast to be accepted, got=%v %q %v", opts, script, ok)
+	}
+	var out, errOut bytes.Buffer
+	status := dumpAST("var a = 1 + 2;\nprint a;", &out, &errOut)
+	expected := "(var a = (+ '1' '2'))\n(print a)\n"
+	if status != 0 || out.String() != expected || errOut.Len() != 0 {
+		t.Fatalf("expected=%q, got=%d %q %q", expected, status, out.String(), errOut.String())
+	}
+	// Nothing is run, so the print statement doesn't print.
+	out.Reset()
+	status = dumpAST("print 1;\nprint ;", &out, &errOut)
+	if status != 65 || out.String() != "(print '1')\n" || errOut.String() != "[line 2] ParserError at ';': Expected expression.\n" {
+		t.Fatalf("expected a parser error, got=%d %q %q", status, out.String(), errOut.String())
+	}
+}
+
+func TestDumpTokens(t *testing.T) {
+	opts, _, ok := parseArgs([]string{"--tokens"})
+	if !ok || !opts.dumpTokens {
+		t.Fatalf("expected --tokens to be accepted, got=%v %v", opts, ok)
+	}
+	var out, errOut bytes.Buffer
+	status := dumpTokens("print \"a\" + 1.5;", &out, &errOut)
+	expected := "print print <nil>\nSTRING \"a\" a\n+ + <nil>\nNUMBER 1.5 1.5\n; ; <nil>\nEOF  <nil>\n"
+	if status != 0 || out.String() != expected || errOut.Len() != 0 {
+		t.Fatalf("expected=%q, got=%d %q %q", expected, status, out.String(), errOut.String())
+	}
+	out.Reset()
+	status = dumpTokens("1;\n#", &out, &errOut)
+	if status != 65 || errOut.String() != "[line 2] ScannerError: Unexpected character.\n" {
+		t.Fatalf("expected a scanner error, got=%d %q", status, errOut.String())
+	}
+}
+
+func TestRunStdin(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stdin.lox")
+	if err := os.WriteFile(path, []byte("setExitCode(4);\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	stdin := os.Stdin
+	os.Stdin = file
+	defer func() { os.Stdin = stdin }()
+
+	if !stdinPiped() {
+		t.Fatalf("expected a redirected stdin not to be treated as a terminal")
+	}
+	status, err := runFile("", options{})
+	if err != nil || status != 4 {
+		t.Fatalf("expected the piped script to run with status 4, got=%d (%v)", status, err)
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	word, start := wordBeforeCursor("print fo", 8)
+	if word != "fo" || start != 6 {
+		t.Fatalf("expected=fo at 6, got=%q at %d", word, start)
+	}
+	word, _ = wordBeforeCursor("foo(bar)", 4)
+	if word != "" {
+		t.Fatalf("expected no word after '(', got=%q", word)
+	}
+	names := []string{"format", "for", "fun", "foo", "for", "bar"}
+	candidates := completionCandidates(names, "fo")
+	if strings.Join(candidates, ",") != "foo,for,format" {
+		t.Fatalf("expected=foo,for,format, got=%v", candidates)
+	}
+	candidates = completionCandidates(names, "ba")
+	if len(candidates) != 1 || candidates[0] != "bar" {
+		t.Fatalf("expected=bar, got=%v", candidates)
+	}
+	if len(completionCandidates(names, "x")) != 0 {
+		t.Fatalf("expected no candidates")
+	}
+}
+
+func TestWarnShadow(t *testing.T) {
+	source := "{\n  var a = 1;\n  {\n    var a = 2;\n  }\n}"
+	opts, _, ok := parseArgs([]string{"--warn-shadow"})
+	if !ok || !opts.warnShadow {
+		t.Fatalf("expected --warn-shadow to be accepted, got=%v %v", opts, ok)
+	}
+	var out, errOut bytes.Buffer
+	interpreter := newInterpreter(opts)
+	interpreter.Out, interpreter.Err = &out, &errOut
+	run(source, interpreter, opts)
+	expected := "[line 4] Warning at 'a': Variable 'a' shadows a variable declared on line 2.\n"
+	if errOut.String() != expected {
+		t.Fatalf("expected=%q, got=%q", expected, errOut.String())
+	}
+
+	errOut.Reset()
+	run(source, interpreter, options{})
+	if errOut.Len() != 0 {
+		t.Fatalf("expected no warnings without --warn-shadow, got=%q", errOut.String())
+	}
+}
+
+func TestMainName(t *testing.T) {
+	dir := t.TempDir()
+	library := filepath.Join(dir, "library.lox")
+	main := filepath.Join(dir, "main.lox")
+	files := map[string]string{
+		library: "if (__name__ == \"__main__\") { print \"library main\"; } else { print \"library imported\"; }\n",
+		main:    "import \"" + library + "\";\nprint __name__;\nimport \"" + library + "\" as lib;\nprint lib.__name__ == __name__;\n",
+	}
+	for path, source := range files {
+		if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var out bytes.Buffer
+	interpreter := newInterpreter(options{})
+	interpreter.Out = &out
+	source, _ := os.ReadFile(main)
+	run(string(source), interpreter, options{})
+	expected := "library imported\n__main__\nlibrary imported\nfalse\n"
+	if out.String() != expected {
+		t.Fatalf("expected=%q, got=%q", expected, out.String())
+	}
+
+	out.Reset()
+	source, _ = os.ReadFile(library)
+	run(string(source), interpreter, options{})
+	if out.String() != "library main\n" {
+		t.Fatalf("expected=%q, got=%q", "library main\n", out.String())
+	}
+}
@@ -0,0 +1,171 @@
+package interpreter
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/lowercasename/golox/logger"
+	"github.com/lowercasename/golox/token"
+)
+
+// In BigNumbers mode, numbers are exact rationals (*big.Rat) rather than
+// float64, so 0.1 + 0.2 == 0.3 holds.
+
+// floatToRat converts a number to a rational using its shortest decimal
+// representation, so a literal like 0.1 becomes exactly 1/10 rather than the
+// nearest binary fraction. Infinities and NaN have no rational, so it
+// returns false for them.
+func floatToRat(n float64) (*big.Rat, bool) {
+	if math.IsInf(n, 0) || math.IsNaN(n) {
+		return nil, false
+	}
+	r, _ := new(big.Rat).SetString(strconv.FormatFloat(n, 'g', -1, 64))
+	return r, true
+}
+
+// toRat returns value as a rational if it is a number of either kind. It
+// reports an error at operator for a float64 that can't be made exact.
+func toRat(operator token.Token, value any) (*big.Rat, bool, error) {
+	switch n := value.(type) {
+	case *big.Rat:
+		return n, true, nil
+	case float64:
+		r, ok := floatToRat(n)
+		if !ok {
+			return nil, true, logger.InterpreterErrorWithLineNumber(operator, fmt.Sprintf("Cannot represent %v exactly.", n))
+		}
+		return r, true, nil
+	}
+	return nil, false, nil
+}
+
+// compareNumbers orders two numbers of either kind, returning a negative
+// number, zero or a positive number as a is less than, equal to or greater
+// than b.
+func compareNumbers(a any, b any) int {
+	fa, aFloat := a.(float64)
+	fb, bFloat := b.(float64)
+	if !aFloat || !bFloat {
+		ra, aOk := numberToRat(a)
+		rb, bOk := numberToRat(b)
+		if aOk && bOk {
+			return ra.Cmp(rb)
+		}
+		// An infinity can only be compared as a float64.
+		fa, fb = numberToFloat(a), numberToFloat(b)
+	}
+	switch {
+	case fa < fb:
+		return -1
+	case fa > fb:
+		return 1
+	}
+	return 0
+}
+
+// numberToRat converts a number of either kind to a rational, if it has one.
+func numberToRat(n any) (*big.Rat, bool) {
+	if r, ok := n.(*big.Rat); ok {
+		return r, true
+	}
+	return floatToRat(n.(float64))
+}
+
+// numberToFloat converts a number of either kind to the nearest float64.
+func numberToFloat(n any) float64 {
+	if r, ok := n.(*big.Rat); ok {
+		f, _ := r.Float64()
+		return f
+	}
+	return n.(float64)
+}
+
+// bigBinary applies an arithmetic or comparison operator to two numbers as
+// rationals. It returns false if the operator isn't one that applies to
+// numbers, or if either operand isn't a number.
+func (i *Interpreter) bigBinary(operator token.Token, leftValue any, rightValue any) (any, bool, error) {
+	switch operator.Type {
+	case token.MINUS, token.PLUS, token.STAR, token.SLASH, token.GREATER, token.GREATER_EQUAL, token.LESS, token.LESS_EQUAL:
+	default:
+		return nil, false, nil
+	}
+	left, leftOk, leftErr := toRat(operator, leftValue)
+	right, rightOk, rightErr := toRat(operator, rightValue)
+	if !leftOk || !rightOk {
+		return nil, false, nil
+	}
+	if leftErr != nil {
+		return nil, true, leftErr
+	}
+	if rightErr != nil {
+		return nil, true, rightErr
+	}
+	switch operator.Type {
+	case token.MINUS:
+		return new(big.Rat).Sub(left, right), true, nil
+	case token.PLUS:
+		return new(big.Rat).Add(left, right), true, nil
+	case token.STAR:
+		return new(big.Rat).Mul(left, right), true, nil
+	case token.SLASH:
+		if right.Sign() == 0 {
+			return nil, true, logger.InterpreterErrorWithLineNumber(operator, i.Messages.DivisionByZero)
+		}
+		return new(big.Rat).Quo(left, right), true, nil
+	case token.GREATER:
+		return left.Cmp(right) > 0, true, nil
+	case token.GREATER_EQUAL:
+		return left.Cmp(right) >= 0, true, nil
+	case token.LESS:
+		return left.Cmp(right) < 0, true, nil
+	}
+	return left.Cmp(right) <= 0, true, nil
+}
+
+// formatRat renders a rational as a decimal. Values with a terminating
+// decimal expansion are exact; others are shown to 16 places.
+func formatRat(r *big.Rat) string {
+	if r.IsInt() {
+		return r.Num().String()
+	}
+	places := 16
+	if digits, ok := terminatingDigits(r.Denom()); ok {
+		places = digits
+	}
+	s := r.FloatString(places)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// terminatingDigits reports how many decimal places are needed to write 1/d
+// exactly, which is only possible if d has no prime factors other than 2 and 5.
+func terminatingDigits(d *big.Int) (int, bool) {
+	n := new(big.Int).Set(d)
+	twos, fives := 0, 0
+	two, five, zero := big.NewInt(2), big.NewInt(5), big.NewInt(0)
+	remainder := new(big.Int)
+	for {
+		quotient, r := new(big.Int).QuoRem(n, two, remainder)
+		if r.Cmp(zero) != 0 {
+			break
+		}
+		n, twos = quotient, twos+1
+	}
+	for {
+		quotient, r := new(big.Int).QuoRem(n, five, remainder)
+		if r.Cmp(zero) != 0 {
+			break
+		}
+		n, fives = quotient, fives+1
+	}
+	if n.Cmp(big.NewInt(1)) != 0 {
+		return 0, false
+	}
+	if twos > fives {
+		return twos, true
+	}
+	return fives, true
+}
@@ -0,0 +1,61 @@
+package interpreter
+
+import (
+	"bufio"
+	"strings"
+	"time"
+)
+
+// lineResult is the outcome of reading one line from the interpreter's input.
+type lineResult struct {
+	line string
+	ok   bool
+}
+
+// reader returns the buffered reader over In, creating it on first use so
+// that lines buffered by one read aren't lost to the next.
+func (i *Interpreter) reader() *bufio.Reader {
+	if i.input == nil {
+		i.input = bufio.NewReader(i.In)
+	}
+	return i.input
+}
+
+// readLine reads a line from In without its line ending, waiting as long as
+// it takes. It returns false at the end of the input.
+func (i *Interpreter) readLine() (string, bool) {
+	// Let a read left over from a timeout finish first, so lines stay in order.
+	if i.pendingLine != nil {
+		result := <-i.pendingLine
+		i.pendingLine = nil
+		return result.line, result.ok
+	}
+	line, err := i.reader().ReadString('\n')
+	return strings.TrimRight(line, "\r\n"), err == nil || line != ""
+}
+
+// readLineTimeout reads a line from In without its line ending, giving up
+// after timeout. It returns false on timeout or at the end of the input.
+//
+// Reads from stdin can't be cancelled, so a timed-out read is left running
+// in its goroutine rather than abandoned. The next call waits on that same
+// read instead of starting another, so at most one reader goroutine exists
+// and a line that arrives late is returned by the next call, not dropped.
+func (i *Interpreter) readLineTimeout(timeout time.Duration) (string, bool) {
+	if i.pendingLine == nil {
+		pending := make(chan lineResult, 1)
+		reader := i.reader()
+		go func() {
+			line, err := reader.ReadString('\n')
+			pending <- lineResult{line: strings.TrimRight(line, "\r\n"), ok: err == nil || line != ""}
+		}()
+		i.pendingLine = pending
+	}
+	select {
+	case result := <-i.pendingLine:
+		i.pendingLine = nil
+		return result.line, result.ok
+	case <-time.After(timeout):
+		return "", false
+	}
+}
@@ -1,17 +1,165 @@
 package interpreter
 
 import (
+	"bufio"
 	"fmt"
-	"time"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/lowercasename/golox/ast"
 	"github.com/lowercasename/golox/environment"
 	"github.com/lowercasename/golox/logger"
+	"github.com/lowercasename/golox/parser"
+	"github.com/lowercasename/golox/scanner"
 	"github.com/lowercasename/golox/token"
 )
 
 type Interpreter struct {
+	globals     *environment.Environment
 	environment *environment.Environment
+	// NilPropagation makes arithmetic involving nil evaluate to nil instead
+	// of raising a type error, like NULL in SQL.
+	NilPropagation bool
+	// Messages holds the text of runtime errors, so embedders can customise it.
+	Messages Messages
+	// AllowFileIO enables natives that touch the host system, such as reading
+	// environment variables. Embedders can disable it to sandbox scripts.
+	AllowFileIO bool
+	// Out receives the output of print statements.
+	Out io.Writer
+	// Err receives runtime errors and diagnostics written with eprint.
+	Err io.Writer
+	// In supplies the lines read by input natives.
+	In io.Reader
+	// BigNumbers makes numbers exact rationals instead of float64, trading
+	// speed for the absence of rounding error.
+	BigNumbers bool
+	// ContinueOnError makes Interpret record a runtime error and carry on with
+	// the next top-level statement, rather than stopping.
+	ContinueOnError bool
+	// MaxFormatDepth limits how deeply nested arrays are rendered by print
+	// and repr. Anything nested deeper is shown as [...].
+	MaxFormatDepth int
+	// ScriptDir is the directory of the script being run, which relative
+	// import paths are resolved against. Empty means the working directory.
+	ScriptDir string
+	// The chain of Lox function calls currently being executed
+	callStack []callFrame
+	// The runtime errors Interpret has run into
+	errors []error
+	// The expressions deferred by each active function call
+	deferred [][]deferredExpr
+	// The exit status requested by setExitCode
+	exitCode int
+	// Regular expressions compiled by the pattern natives, keyed by source
+	patterns map[string]*regexp.Regexp
+	// Buffered reader over In, created on first use
+	input *bufio.Reader
+	// A line read that is still in progress, left over from a timed-out read
+	pendingLine chan lineResult
+	// The number of arrays created by the script, for stats
+	arraysCreated int
+	// The class whose method is executing, which may use private members
+	currentClass *LoxClass
+	// The cleaned paths of the modules being imported, to catch circular
+	// imports
+	importing map[string]bool
+}
+
+// returnValue carries the value of a return statement out to the function
+// call it returns from. It travels as an error so that the blocks and loops
+// in between stop running and unwind on the way.
+type returnValue struct {
+	value any
+}
+
+func (r *returnValue) Error() string {
+	return "Cannot return from top-level code."
+}
+
+// loopSignal carries a break or continue statement out to the loop it
+// applies to, in the same way as returnValue.
+type loopSignal struct {
+	keyword token.Token
+}
+
+func (l *loopSignal) Error() string {
+	return "Cannot use '" + l.keyword.Lexeme + "' outside of a loop."
+}
+
+// flusher is implemented by buffered writers such as *bufio.Writer.
+type flusher interface {
+	Flush() error
+}
+
+// flush writes out anything buffered by w, so that output appears before the
+// script goes on to wait for input.
+func flush(w io.Writer) {
+	if f, ok := w.(flusher); ok {
+		f.Flush()
+	}
+}
+
+// deferredExpr is an expression from a defer statement, along with the
+// environment it must be evaluated in.
+type deferredExpr struct {
+	expr        ast.Expr
+	environment *environment.Environment
+}
+
+// callFrame records a call to a Lox function, for stack traces.
+type callFrame struct {
+	name string
+	line int
+}
+
+func (f callFrame) String() string {
+	return fmt.Sprintf("%s() called at line %d", f.name, f.line)
+}
+
+// stackError is a runtime error annotated with the calls that led to it.
+type stackError struct {
+	err   error
+	trace []string
+}
+
+func (e *stackError) Error() string {
+	message := e.err.Error()
+	for _, frame := range e.trace {
+		message += "    in " + frame + "\n"
+	}
+	return message
+}
+
+func (e *stackError) Unwrap() error {
+	return e.err
+}
+
+// Messages holds the text of runtime errors raised by the interpreter.
+type Messages struct {
+	DivisionByZero string
+	NotCallable    string
+	PlusOperands   string
+}
+
+// DefaultMessages are the runtime error messages used unless configured otherwise.
+var DefaultMessages = Messages{
+	DivisionByZero: "Division by zero.",
+	NotCallable:    "Can only call functions and classes.",
+	PlusOperands:   "Operands of '+' must both be either numbers or strings.",
+}
+
+// FunMessages are the whimsical variants enabled by the --fun flag.
+var FunMessages = Messages{
+	DivisionByZero: "Division by zero. Eldritch horrors invoked.",
+	NotCallable:    DefaultMessages.NotCallable,
+	PlusOperands:   DefaultMessages.PlusOperands,
 }
 
 type Callable interface {
@@ -19,9 +167,18 @@ type Callable interface {
 	Arity() int
 }
 
+// variadic is the arity of a native function that accepts a varying number
+// of arguments and checks them itself.
+const variadic = -1
+
 type Function struct {
 	Callable
 	declaration *ast.Function
+	// The environment the function was declared in, which its body can see
+	closure *environment.Environment
+	// The class the function was declared in, if any, whose private members
+	// its body can access
+	class *LoxClass
 }
 
 type NativeFunction struct {
@@ -43,64 +200,353 @@ func (f Function) Arity() int {
 }
 
 func (f Function) Call(interpreter *Interpreter, arguments []any) (any, error) {
-	interpreter.environment = environment.NewEnclosed(interpreter.environment)
+	// The caller carries on in its own environment however the call ends.
+	previousEnvironment := interpreter.environment
+	previousClass := interpreter.currentClass
+	defer func() {
+		interpreter.environment = previousEnvironment
+		interpreter.currentClass = previousClass
+	}()
+	interpreter.environment = environment.NewEnclosed(f.closure)
+	interpreter.currentClass = f.class
 	for i, param := range f.declaration.Parameters {
-		interpreter.environment.Define(param.Lexeme, arguments[i])
+		if err := checkType(param, parameterType(f.declaration, i), arguments[i], "argument"); err != nil {
+			return nil, err
+		}
+		if !isDiscard(param) {
+			interpreter.environment.Define(param.Lexeme, arguments[i])
+		}
 	}
+	// Check the function's contract, if it has one, with the parameters bound.
+	if f.declaration.Guard != nil {
+		satisfied, err := interpreter.evaluate(f.declaration.Guard)
+		if err != nil {
+			return nil, err
+		}
+		if !isTruthy(satisfied) {
+			return nil, logger.InterpreterErrorWithLineNumber(f.declaration.Name, "Contract 'where "+f.declaration.GuardText+"' violated.")
+		}
+	}
+	interpreter.deferred = append(interpreter.deferred, nil)
+	var err error
+	var result any = nil
 	for _, statement := range f.declaration.Body {
-		_, err := interpreter.evaluate(statement)
+		_, err = interpreter.evaluate(statement)
 		if err != nil {
+			break
+		}
+	}
+	// A return statement unwinds to here carrying the function's result.
+	if ret, ok := err.(*returnValue); ok {
+		result = ret.value
+		err = nil
+	}
+	// Deferred expressions run however the body exits. An error from the body
+	// takes precedence over one from a deferred expression.
+	deferredErr := interpreter.runDeferred()
+	if err == nil {
+		err = deferredErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := checkType(f.declaration.Name, f.declaration.ReturnType, result, "return value of"); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// bind returns a copy of a method whose body sees this as the instance.
+func (f Function) bind(instance *LoxInstance) Function {
+	closure := environment.NewEnclosed(f.closure)
+	closure.Define("this", instance)
+	return Function{declaration: f.declaration, closure: closure, class: f.class}
+}
+
+// Module is the value bound by `import "path" as name;`. Its properties are
+// the top-level definitions of the imported script.
+type Module struct {
+	name        string
+	environment *environment.Environment
+}
+
+func (m *Module) get(name token.Token) (any, error) {
+	if _, ok := m.environment.Values[name.Lexeme]; ok {
+		return m.environment.Get(name)
+	}
+	return nil, logger.InterpreterErrorWithLineNumber(name, "Undefined property '"+name.Lexeme+"'.")
+}
+
+func (m *Module) String() string {
+	return "<module " + m.name + ">"
+}
+
+// LoxEnum is the value declared by `enum Name { A, B }`. Its members are
+// distinct values that are only equal to themselves.
+type LoxEnum struct {
+	name    string
+	members map[string]*EnumMember
+}
+
+// EnumMember is one member of an enum.
+type EnumMember struct {
+	enum *LoxEnum
+	name string
+}
+
+func newEnum(declaration *ast.Enum) *LoxEnum {
+	enum := &LoxEnum{name: declaration.Name.Lexeme, members: make(map[string]*EnumMember)}
+	for _, member := range declaration.Members {
+		enum.members[member.Lexeme] = &EnumMember{enum: enum, name: member.Lexeme}
+	}
+	return enum
+}
+
+func (e *LoxEnum) get(name token.Token) (any, error) {
+	if member, ok := e.members[name.Lexeme]; ok {
+		return member, nil
+	}
+	return nil, logger.InterpreterErrorWithLineNumber(name, "Undefined member '"+name.Lexeme+"' of enum '"+e.name+"'.")
+}
+
+func (e *LoxEnum) String() string {
+	return "<enum " + e.name + ">"
+}
+
+func (m *EnumMember) String() string {
+	return m.enum.name + "." + m.name
+}
+
+// LoxClass is the value declared by `class Name { ... }`. Calling it creates
+// an instance.
+type LoxClass struct {
+	Callable
+	name       string
+	superclass *LoxClass
+	methods    map[string]Function
+}
+
+// findMethod looks up a method on the class, then on its superclasses.
+func (c *LoxClass) findMethod(name string) (Function, bool) {
+	if method, ok := c.methods[name]; ok {
+		return method, true
+	}
+	if c.superclass != nil {
+		return c.superclass.findMethod(name)
+	}
+	return Function{}, false
+}
+
+// isA reports whether the class is other or inherits from it.
+func (c *LoxClass) isA(other *LoxClass) bool {
+	for class := c; class != nil; class = class.superclass {
+		if class == other {
+			return true
+		}
+	}
+	return false
+}
+
+// Arity is the arity of the class's init method, which may be inherited, or
+// 0 if it has none.
+func (c *LoxClass) Arity() int {
+	if initializer, ok := c.findMethod("init"); ok {
+		return initializer.Arity()
+	}
+	return 0
+}
+
+// Call creates an instance and runs init on it. The instance is the result
+// whatever init returns.
+func (c *LoxClass) Call(interpreter *Interpreter, arguments []any) (any, error) {
+	instance := &LoxInstance{class: c, fields: make(map[string]any)}
+	if initializer, ok := c.findMethod("init"); ok {
+		if _, err := initializer.bind(instance).Call(interpreter, arguments); err != nil {
 			return nil, err
 		}
 	}
-	return nil, nil
+	return instance, nil
+}
+
+func (c *LoxClass) String() string {
+	return "<class " + c.name + ">"
+}
+
+// LoxInstance is an object created by calling a class. Its fields are set by
+// assigning to them and shadow the class's methods. Members whose names start
+// with an underscore are private to the class's own methods.
+type LoxInstance struct {
+	class  *LoxClass
+	fields map[string]any
+}
+
+func (o *LoxInstance) get(name token.Token) (any, error) {
+	if value, ok := o.fields[name.Lexeme]; ok {
+		return value, nil
+	}
+	if method, ok := o.class.findMethod(name.Lexeme); ok {
+		return method.bind(o), nil
+	}
+	return nil, logger.InterpreterErrorWithLineNumber(name, "Undefined property '"+name.Lexeme+"'.")
+}
+
+func (o *LoxInstance) set(name token.Token, value any) {
+	o.fields[name.Lexeme] = value
+}
+
+func (o *LoxInstance) String() string {
+	return "<" + o.class.name + " instance>"
+}
+
+// LoxArray is an ordered, mutable collection of values.
+type LoxArray struct {
+	Elements []any
+}
+
+// newArray creates an array for a script, counting it for stats.
+func (i *Interpreter) newArray(elements []any) *LoxArray {
+	i.arraysCreated++
+	return &LoxArray{Elements: elements}
 }
 
 func New() *Interpreter {
 	globals := environment.New()
-	globals.Define("clock", NativeFunction{
-		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
-			// Return time in seconds
-			return int(time.Now().UnixMilli()) / 1000, nil
-		},
-		arity: 0,
-	})
-	globals.Define("sqrt", NativeFunction{
-		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
-			argument := arguments[0].(float64)
-			return float64(argument * argument), nil
-		},
-		arity: 1,
-	})
+	defineNatives(globals)
+	globals.Define("argv", &LoxArray{Elements: []any{}})
+	globals.Define("PI", math.Pi)
+	globals.Define("E", math.E)
+	// Code run directly, rather than imported, sees __name__ as "__main__".
+	globals.Define("__name__", "__main__")
 	return &Interpreter{
-		environment: globals,
+		globals:        globals,
+		environment:    globals,
+		Messages:       DefaultMessages,
+		AllowFileIO:    true,
+		MaxFormatDepth: DefaultMaxFormatDepth,
+		Out:            os.Stdout,
+		Err:            os.Stderr,
+		In:             os.Stdin,
 	}
 }
 
-func (i *Interpreter) Interpret(expressions []ast.Expr) {
+// NewWithOutput creates an interpreter that prints to out and reports errors
+// to errOut, rather than to stdout and stderr.
+func NewWithOutput(out, errOut io.Writer) *Interpreter {
+	i := New()
+	i.Out = out
+	i.Err = errOut
+	return i
+}
+
+// NewWithIO creates an interpreter that reads input from in as well as
+// writing to out and errOut.
+func NewWithIO(in io.Reader, out, errOut io.Writer) *Interpreter {
+	i := NewWithOutput(out, errOut)
+	i.In = in
+	return i
+}
+
+// Interpret runs a program and returns the first runtime error, if any. It
+// doesn't print errors; with ContinueOnError, every error it ran into is
+// available from Errors.
+func (i *Interpreter) Interpret(expressions []ast.Expr) error {
+	var first error
 	for _, expr := range expressions {
 		_, err := i.evaluate(expr)
 		if err != nil {
-			fmt.Print(err)
-			return
+			i.errors = append(i.errors, err)
+			if first == nil {
+				first = err
+			}
+			if !i.ContinueOnError {
+				return first
+			}
 		}
 	}
+	return first
+}
+
+// SetArgs exposes command-line arguments to scripts as the global array argv.
+func (i *Interpreter) SetArgs(args []string) {
+	elements := make([]any, len(args))
+	for n, arg := range args {
+		elements[n] = arg
+	}
+	i.globals.Define("argv", &LoxArray{Elements: elements})
+}
+
+// DefineNative makes a Go function callable from scripts as a global. The
+// function receives the evaluated arguments; an arity of -1 accepts any
+// number of them. Numbers passed in and returned are float64.
+func (i *Interpreter) DefineNative(name string, arity int, fn func(args []any) (any, error)) {
+	i.globals.Define(name, NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			return fn(arguments)
+		},
+		arity: arity,
+	})
+}
+
+// Global returns the value of a global variable, and whether it is defined.
+// A lazy constant is computed as if the script had read it. A variable that
+// can't be read, such as one declared without a value, is reported as nil.
+func (i *Interpreter) Global(name string) (any, bool) {
+	if _, ok := i.globals.Values[name]; !ok {
+		return nil, false
+	}
+	value, err := i.globals.Get(token.Token{Type: token.IDENTIFIER, Lexeme: name})
+	if err != nil {
+		return nil, true
+	}
+	return value, true
+}
+
+// GlobalNames returns the names defined in the global environment.
+func (i *Interpreter) GlobalNames() []string {
+	names := make([]string, 0, len(i.globals.Values))
+	for name := range i.globals.Values {
+		names = append(names, name)
+	}
+	return names
+}
+
+// ExitCode returns the exit status a script has requested with setExitCode,
+// to be used when it finishes normally.
+func (i *Interpreter) ExitCode() int {
+	return i.exitCode
+}
+
+// Errors returns the runtime errors Interpret has run into so far.
+func (i *Interpreter) Errors() []error {
+	return i.errors
+}
+
+// Evaluate runs a single parsed node in the current environment and returns
+// its value, so hosts can evaluate ASTs they build themselves.
+func (i *Interpreter) Evaluate(expr ast.Expr) (any, error) {
+	return i.evaluate(expr)
 }
 
 func (i *Interpreter) evaluate(expr ast.Expr) (any, error) {
-	switch expr.(type) {
-	case *ast.Literal:
-		v, err := i.literal(expr)
-		if err != nil {
-			return nil, err
+	// Literals and groupings are the most common nodes in arithmetic, so
+	// handle them directly rather than going through the full switch. A
+	// literal's value is already boxed on the node and is returned as is.
+	for {
+		grouping, ok := expr.(*ast.Grouping)
+		if !ok {
+			break
 		}
-		return v, nil
-	case *ast.Grouping:
-		v, err := i.grouping(expr)
-		if err != nil {
-			return nil, err
+		expr = grouping.Expression
+	}
+	if literal, ok := expr.(*ast.Literal); ok {
+		if n, ok := literal.Value.(float64); ok && i.BigNumbers {
+			if r, ok := floatToRat(n); ok {
+				return r, nil
+			}
 		}
-		return v, nil
+		return literal.Value, nil
+	}
+	switch expr.(type) {
 	case *ast.Unary:
 		v, err := i.unary(expr)
 		if err != nil {
@@ -143,6 +589,12 @@ func (i *Interpreter) evaluate(expr ast.Expr) (any, error) {
 			return nil, err
 		}
 		return v, nil
+	case *ast.This:
+		v, err := i.environment.Get(expr.(*ast.This).Keyword)
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
 	case *ast.Block:
 		v, err := i.block(expr)
 		if err != nil {
@@ -155,6 +607,12 @@ func (i *Interpreter) evaluate(expr ast.Expr) (any, error) {
 			return nil, err
 		}
 		return v, nil
+	case *ast.Ternary:
+		v, err := i.ternary(expr)
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
 	case *ast.Logical:
 		v, err := i.logical(expr)
 		if err != nil {
@@ -168,35 +626,182 @@ func (i *Interpreter) evaluate(expr ast.Expr) (any, error) {
 		}
 		return v, nil
 	case *ast.Call:
-		v, err := i.evaluate(expr.(*ast.Call).Callee)
+		v, err := i.call(expr)
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	case *ast.Function:
+		function := Function{declaration: expr.(*ast.Function), closure: i.environment, class: i.currentClass}
+		i.environment.Define(function.declaration.Name.Lexeme, function)
+		return nil, nil
+	case *ast.Get:
+		v, err := i.get(expr)
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	case *ast.ListLiteral:
+		v, err := i.listLiteral(expr)
 		if err != nil {
 			return nil, err
 		}
-		arguments := expr.(*ast.Call).Arguments
-		// Evaluate the arguments.
-		var evaluatedArguments []any
-		for _, argument := range arguments {
-			argument, err := i.evaluate(argument)
+		return v, nil
+	case *ast.Index:
+		v, err := i.index(expr)
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	case *ast.SetIndex:
+		v, err := i.setIndex(expr)
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	case *ast.Set:
+		v, err := i.set(expr)
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	case *ast.Class:
+		_, err := i.classStmt(expr)
+		if err != nil {
+			return nil, err
+		}
+		return nil, nil
+	case *ast.Super:
+		v, err := i.super(expr)
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	case *ast.Defer:
+		deferStmt := expr.(*ast.Defer)
+		// The parser only allows defer in a function, but a hand-built tree
+		// may have one at the top level.
+		if len(i.deferred) == 0 {
+			return nil, logger.InterpreterErrorWithLineNumber(deferStmt.Keyword, "Cannot use 'defer' outside of a function.")
+		}
+		top := len(i.deferred) - 1
+		i.deferred[top] = append(i.deferred[top], deferredExpr{expr: deferStmt.Expression, environment: i.environment})
+		return nil, nil
+	case *ast.With:
+		_, err := i.withStmt(expr)
+		if err != nil {
+			return nil, err
+		}
+		return nil, nil
+	case *ast.Return:
+		returnStmt := expr.(*ast.Return)
+		var v any = nil
+		if returnStmt.Value != nil {
+			var err error
+			v, err = i.evaluate(returnStmt.Value)
 			if err != nil {
 				return nil, err
 			}
+		}
+		return nil, &returnValue{value: v}
+	case *ast.Break:
+		return nil, &loopSignal{keyword: expr.(*ast.Break).Keyword}
+	case *ast.Continue:
+		return nil, &loopSignal{keyword: expr.(*ast.Continue).Keyword}
+	case *ast.Enum:
+		enumStmt := expr.(*ast.Enum)
+		i.environment.Define(enumStmt.Name.Lexeme, newEnum(enumStmt))
+		return nil, nil
+	case *ast.Import:
+		_, err := i.importStmt(expr)
+		if err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+	return nil, logger.InterpreterError("Unknown expression type: " + fmt.Sprintf("%T", expr))
+}
+
+func (i *Interpreter) call(expr ast.Expr) (any, error) {
+	call := expr.(*ast.Call)
+	v, err := i.evaluate(call.Callee)
+	if err != nil {
+		return nil, err
+	}
+	// Evaluate the arguments.
+	var evaluatedArguments []any
+	for _, argument := range call.Arguments {
+		spread, isSpread := argument.(*ast.Spread)
+		if isSpread {
+			argument = spread.Expression
+		}
+		argument, err := i.evaluate(argument)
+		if err != nil {
+			return nil, err
+		}
+		if !isSpread {
 			evaluatedArguments = append(evaluatedArguments, argument)
+			continue
 		}
-		// Get the function from the callee.
-		c, ok := v.(Callable)
+		// A spread array contributes each of its elements as an argument.
+		array, ok := argument.(*LoxArray)
 		if !ok {
-			return nil, logger.InterpreterError("Can only call functions and classes.")
-		}
-		if len(evaluatedArguments) != c.Arity() {
-			return nil, logger.InterpreterError(fmt.Sprintf("Expected %d arguments but got %d.", c.Arity(), len(evaluatedArguments)))
+			return nil, logger.InterpreterErrorWithLineNumber(spread.Operator, "Can only spread arrays into arguments.")
 		}
+		evaluatedArguments = append(evaluatedArguments, array.Elements...)
+	}
+	// Get the function from the callee.
+	c, ok := v.(Callable)
+	if !ok {
+		return nil, logger.InterpreterErrorWithLineNumber(call.Paren, i.Messages.NotCallable+" Tried to call "+describeType(v)+".")
+	}
+	if c.Arity() != variadic && len(evaluatedArguments) != c.Arity() {
+		return nil, logger.InterpreterError(fmt.Sprintf("Expected %d arguments but got %d.", c.Arity(), len(evaluatedArguments)))
+	}
+	function, ok := c.(Function)
+	if !ok {
 		return c.Call(i, evaluatedArguments)
-	case *ast.Function:
-		function := Function{declaration: expr.(*ast.Function)}
-		i.environment.Define(function.declaration.Name.Lexeme, function)
-		return nil, nil
 	}
-	return nil, logger.InterpreterError("Unknown expression type: " + fmt.Sprintf("%T", expr))
+	// Keep track of calls to Lox functions so errors can show how they were reached.
+	i.callStack = append(i.callStack, callFrame{name: function.declaration.Name.Lexeme, line: call.Paren.Line})
+	v, err = function.Call(i, evaluatedArguments)
+	if err != nil {
+		// Record the stack where the error happened, not where it's caught.
+		if _, ok := err.(*stackError); !ok {
+			err = &stackError{err: err, trace: i.stackTrace()}
+		}
+	}
+	i.callStack = i.callStack[:len(i.callStack)-1]
+	return v, err
+}
+
+// runDeferred evaluates the expressions deferred by the innermost function
+// call, most recent first, and discards them. All of them run even if one
+// fails; the first error is returned.
+func (i *Interpreter) runDeferred() error {
+	top := len(i.deferred) - 1
+	deferred := i.deferred[top]
+	i.deferred = i.deferred[:top]
+	previousEnvironment := i.environment
+	var firstErr error
+	for n := len(deferred) - 1; n >= 0; n-- {
+		i.environment = deferred[n].environment
+		_, err := i.evaluate(deferred[n].expr)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	i.environment = previousEnvironment
+	return firstErr
+}
+
+// stackTrace describes the active calls to Lox functions, innermost first.
+func (i *Interpreter) stackTrace() []string {
+	trace := make([]string, 0, len(i.callStack))
+	for n := len(i.callStack) - 1; n >= 0; n-- {
+		trace = append(trace, i.callStack[n].String())
+	}
+	return trace
 }
 
 func (i *Interpreter) block(expr ast.Expr) (any, error) {
@@ -217,9 +822,17 @@ func (i *Interpreter) block(expr ast.Expr) (any, error) {
 	return nil, nil
 }
 
-func (i *Interpreter) literal(expr ast.Expr) (any, error) {
-	v := expr.(*ast.Literal).Value
-	return v, nil
+// Evaluate one branch of a ternary, leaving the other untouched.
+func (i *Interpreter) ternary(expr ast.Expr) (any, error) {
+	ternary := expr.(*ast.Ternary)
+	condition, err := i.evaluate(ternary.Condition)
+	if err != nil {
+		return nil, err
+	}
+	if isTruthy(condition) {
+		return i.evaluate(ternary.Then)
+	}
+	return i.evaluate(ternary.Else)
 }
 
 func (i *Interpreter) logical(expr ast.Expr) (any, error) {
@@ -243,21 +856,18 @@ func (i *Interpreter) logical(expr ast.Expr) (any, error) {
 	return i.evaluate(logicalExpr.Right)
 }
 
-func (i *Interpreter) grouping(expr ast.Expr) (any, error) {
-	grouping := expr.(*ast.Grouping)
-	v, err := i.evaluate(grouping.Expression)
-	if err != nil {
-		return nil, err
-	}
-	return v, nil
-}
-
 func (i *Interpreter) unary(expr ast.Expr) (any, error) {
 	unary := expr.(*ast.Unary)
 	right, err := i.evaluate(unary.Right)
 	if err != nil {
 		return nil, err
 	}
+	if i.NilPropagation && unary.Operator.Type == token.MINUS && right == nil {
+		return nil, nil
+	}
+	if r, ok := right.(*big.Rat); ok && unary.Operator.Type == token.MINUS {
+		return new(big.Rat).Neg(r), nil
+	}
 	switch unary.Operator.Type {
 	case token.MINUS:
 		err := checkNumberOperand(unary.Operator, right)
@@ -281,6 +891,20 @@ func (i *Interpreter) binary(expr ast.Expr) (any, error) {
 	if err != nil {
 		return nil, err
 	}
+	if i.NilPropagation && (left == nil || right == nil) {
+		switch binary.Operator.Type {
+		case token.MINUS, token.SLASH, token.STAR, token.PLUS:
+			return nil, nil
+		}
+	}
+	if i.BigNumbers {
+		if v, ok, err := i.bigBinary(binary.Operator, left, right); ok {
+			return v, err
+		}
+	}
+	if v, ok := compareStrings(binary.Operator, left, right); ok {
+		return v, nil
+	}
 	switch binary.Operator.Type {
 	case token.MINUS:
 		err := checkNumberOperands(binary.Operator, left, right)
@@ -295,7 +919,7 @@ func (i *Interpreter) binary(expr ast.Expr) (any, error) {
 		}
 		// Check for division by zero.
 		if right.(float64) == 0 {
-			return nil, logger.InterpreterErrorWithLineNumber(binary.Operator, "Division by zero. Eldritch horrors invoked.")
+			return nil, logger.InterpreterErrorWithLineNumber(binary.Operator, i.Messages.DivisionByZero)
 		}
 		return left.(float64) / right.(float64), nil
 	case token.STAR:
@@ -314,6 +938,10 @@ func (i *Interpreter) binary(expr ast.Expr) (any, error) {
 				// If the left term is a number and the right term is a string, convert the number to a string and concatenate.
 				return fmt.Sprintf("%v%v", leftTerm, rightTerm), nil
 			}
+		case *big.Rat:
+			if rightTerm, ok := right.(string); ok {
+				return formatRat(leftTerm) + rightTerm, nil
+			}
 		case string:
 			switch rightTerm := right.(type) {
 			case float64:
@@ -321,9 +949,11 @@ func (i *Interpreter) binary(expr ast.Expr) (any, error) {
 				return fmt.Sprintf("%v%v", leftTerm, rightTerm), nil
 			case string:
 				return leftTerm + rightTerm, nil
+			case *big.Rat:
+				return leftTerm + formatRat(rightTerm), nil
 			}
 		}
-		return nil, logger.InterpreterErrorWithLineNumber(binary.Operator, "Operands of '+' must both be either numbers or strings.")
+		return nil, logger.InterpreterErrorWithLineNumber(binary.Operator, i.Messages.PlusOperands)
 	case token.GREATER:
 		err := checkNumberOperands(binary.Operator, left, right)
 		if err != nil {
@@ -376,13 +1006,17 @@ func (i *Interpreter) print(expr ast.Expr) (any, error) {
 	if err != nil {
 		return nil, err
 	}
-	fmt.Println(v)
+	fmt.Fprintln(i.Out, i.stringify(v))
+	flush(i.Out)
 	return nil, nil
 }
 
 // Declare a variable in the current scope.
 func (i *Interpreter) variableStmt(expr ast.Expr) (any, error) {
 	variableStmt := expr.(*ast.Var)
+	if variableStmt.Lazy {
+		return i.lazyConstant(variableStmt)
+	}
 	var v any = nil
 	var err error
 	// If the variable has an initializer, evaluate it.
@@ -392,8 +1026,49 @@ func (i *Interpreter) variableStmt(expr ast.Expr) (any, error) {
 			return nil, err
 		}
 	}
-	// Declare the variable. If it wasn't initialized, it will be nil.
-	i.environment.Define(variableStmt.Name.Lexeme, v)
+	// The throwaway variable _ is never stored.
+	if isDiscard(variableStmt.Name) {
+		return nil, nil
+	}
+	if err := checkType(variableStmt.Name, variableStmt.Type, v, "variable"); err != nil {
+		return nil, err
+	}
+	// Declare the variable. If it wasn't initialized, reading it is an error
+	// until it's assigned.
+	if variableStmt.Constant {
+		i.environment.DefineConstant(variableStmt.Name.Lexeme, v)
+	} else if variableStmt.Initializer == nil {
+		i.environment.DefineUninitialized(variableStmt.Name.Lexeme)
+	} else {
+		i.environment.Define(variableStmt.Name.Lexeme, v)
+	}
+	if variableStmt.Type != "" && variableStmt.Type != "any" {
+		i.environment.SetType(variableStmt.Name.Lexeme, variableStmt.Type)
+	}
+	return nil, nil
+}
+
+// Declare a lazy constant, whose initializer runs in the declaring scope the
+// first time the constant is read.
+func (i *Interpreter) lazyConstant(declaration *ast.Var) (any, error) {
+	if isDiscard(declaration.Name) {
+		return nil, nil
+	}
+	scope := i.environment
+	thunk := &environment.Lazy{Evaluate: func() (any, error) {
+		previousEnvironment := i.environment
+		i.environment = scope
+		v, err := i.evaluate(declaration.Initializer)
+		i.environment = previousEnvironment
+		if err != nil {
+			return nil, err
+		}
+		if err := checkType(declaration.Name, declaration.Type, v, "variable"); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}}
+	scope.DefineConstant(declaration.Name.Lexeme, thunk)
 	return nil, nil
 }
 
@@ -409,11 +1084,24 @@ func (i *Interpreter) whileStmt(expr ast.Expr) (any, error) {
 		if !isTruthy(condition) {
 			break
 		}
-		// Evaluate the body.
+		// Evaluate the body. A break leaves the loop, and a continue goes on
+		// to the increment.
 		_, err = i.evaluate(whileStmt.Body)
+		if signal, ok := err.(*loopSignal); ok {
+			if signal.keyword.Type == token.BREAK {
+				break
+			}
+			err = nil
+		}
 		if err != nil {
 			return nil, err
 		}
+		if whileStmt.Increment != nil {
+			_, err = i.evaluate(whileStmt.Increment)
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 	return nil, nil
 }
@@ -427,6 +1115,20 @@ func (i *Interpreter) assign(expr ast.Expr) (any, error) {
 	if err != nil {
 		return nil, err
 	}
+	if isDiscard(assign.Name) {
+		return v, nil
+	}
+	// name := value declares the variable in the current scope, shadowing any
+	// outer variable of the same name, and evaluates to the value.
+	if assign.Declare {
+		if err := i.environment.Declare(assign.Name, v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+	if err := checkType(assign.Name, i.environment.TypeOf(assign.Name.Lexeme), v, "variable"); err != nil {
+		return nil, err
+	}
 	_, err2 := i.environment.Assign(assign.Name, v)
 	if err2 != nil {
 		return nil, err2
@@ -434,6 +1136,319 @@ func (i *Interpreter) assign(expr ast.Expr) (any, error) {
 	return v, nil
 }
 
+// Access a property of an object.
+func (i *Interpreter) get(expr ast.Expr) (any, error) {
+	get := expr.(*ast.Get)
+	object, err := i.evaluate(get.Object)
+	if err != nil {
+		return nil, err
+	}
+	if err := i.checkPrivate(object, get.Name); err != nil {
+		return nil, err
+	}
+	return property(object, get.Name)
+}
+
+// Declare a class. Methods of a subclass close over an environment that
+// binds super to the superclass.
+func (i *Interpreter) classStmt(expr ast.Expr) (any, error) {
+	classStmt := expr.(*ast.Class)
+	class := &LoxClass{name: classStmt.Name.Lexeme, methods: make(map[string]Function)}
+	closure := i.environment
+	if classStmt.Superclass != nil {
+		superclass, err := i.evaluate(classStmt.Superclass)
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		class.superclass, ok = superclass.(*LoxClass)
+		if !ok {
+			return nil, logger.InterpreterErrorWithLineNumber(classStmt.Superclass.Name, "Superclass must be a class.")
+		}
+		closure = environment.NewEnclosed(closure)
+		closure.Define("super", class.superclass)
+	}
+	for _, method := range classStmt.Methods {
+		class.methods[method.Name.Lexeme] = Function{declaration: method, closure: closure, class: class}
+	}
+	i.environment.Define(classStmt.Name.Lexeme, class)
+	return nil, nil
+}
+
+// Look up a superclass method, bound to the current instance.
+func (i *Interpreter) super(expr ast.Expr) (any, error) {
+	super := expr.(*ast.Super)
+	v, err := i.environment.Get(super.Keyword)
+	if err != nil {
+		return nil, err
+	}
+	superclass := v.(*LoxClass)
+	this, err := i.environment.Get(token.Token{Type: token.THIS, Lexeme: "this", Line: super.Keyword.Line})
+	if err != nil {
+		return nil, err
+	}
+	method, ok := superclass.findMethod(super.Method.Lexeme)
+	if !ok {
+		return nil, logger.InterpreterErrorWithLineNumber(super.Method, "Undefined property '"+super.Method.Lexeme+"'.")
+	}
+	return method.bind(this.(*LoxInstance)), nil
+}
+
+// Create an array from the values of a list literal.
+func (i *Interpreter) listLiteral(expr ast.Expr) (any, error) {
+	list := expr.(*ast.ListLiteral)
+	elements := make([]any, 0, len(list.Elements))
+	for _, element := range list.Elements {
+		v, err := i.evaluate(element)
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, v)
+	}
+	return i.newArray(elements), nil
+}
+
+// Read an element of an array, or a character of a string as a string of
+// its own.
+func (i *Interpreter) index(expr ast.Expr) (any, error) {
+	index := expr.(*ast.Index)
+	v, err := i.evaluate(index.Object)
+	if err != nil {
+		return nil, err
+	}
+	switch object := v.(type) {
+	case *LoxArray:
+		n, err := i.checkIndex(index.Bracket, index.Index, len(object.Elements), "an array")
+		if err != nil {
+			return nil, err
+		}
+		return object.Elements[n], nil
+	case string:
+		// Strings are indexed by character, not byte.
+		runes := []rune(object)
+		n, err := i.checkIndex(index.Bracket, index.Index, len(runes), "a string")
+		if err != nil {
+			return nil, err
+		}
+		return string(runes[n]), nil
+	}
+	return nil, logger.InterpreterErrorWithLineNumber(index.Bracket, "Only arrays and strings can be indexed.")
+}
+
+// Assign to an element of an array.
+func (i *Interpreter) setIndex(expr ast.Expr) (any, error) {
+	setIndex := expr.(*ast.SetIndex)
+	v, err := i.evaluate(setIndex.Object)
+	if err != nil {
+		return nil, err
+	}
+	array, ok := v.(*LoxArray)
+	if !ok {
+		if _, ok := v.(string); ok {
+			return nil, logger.InterpreterErrorWithLineNumber(setIndex.Bracket, "Cannot assign to a character of a string.")
+		}
+		return nil, logger.InterpreterErrorWithLineNumber(setIndex.Bracket, "Only arrays can be assigned to by index.")
+	}
+	n, err := i.checkIndex(setIndex.Bracket, setIndex.Index, len(array.Elements), "an array")
+	if err != nil {
+		return nil, err
+	}
+	value, err := i.evaluate(setIndex.Value)
+	if err != nil {
+		return nil, err
+	}
+	array.Elements[n] = value
+	return value, nil
+}
+
+// checkIndex evaluates an index into something of the given length,
+// checking that it is a whole number within bounds.
+func (i *Interpreter) checkIndex(bracket token.Token, index ast.Expr, length int, what string) (int, error) {
+	v, err := i.evaluate(index)
+	if err != nil {
+		return 0, err
+	}
+	if r, ok := v.(*big.Rat); ok {
+		v, _ = r.Float64()
+	}
+	n, ok := v.(float64)
+	if !ok || n != math.Trunc(n) {
+		return 0, logger.InterpreterErrorWithLineNumber(bracket, "Index must be a whole number.")
+	}
+	if n < 0 || n >= float64(length) {
+		return 0, logger.InterpreterErrorWithLineNumber(bracket, fmt.Sprintf("Index %v is out of bounds for %s of length %d.", n, what, length))
+	}
+	return int(n), nil
+}
+
+// Assign to a field of an instance.
+func (i *Interpreter) set(expr ast.Expr) (any, error) {
+	set := expr.(*ast.Set)
+	object, err := i.evaluate(set.Object)
+	if err != nil {
+		return nil, err
+	}
+	instance, ok := object.(*LoxInstance)
+	if !ok {
+		return nil, logger.InterpreterErrorWithLineNumber(set.Name, "Only instances have fields.")
+	}
+	if err := i.checkPrivate(instance, set.Name); err != nil {
+		return nil, err
+	}
+	value, err := i.evaluate(set.Value)
+	if err != nil {
+		return nil, err
+	}
+	instance.set(set.Name, value)
+	return value, nil
+}
+
+// checkPrivate rejects access to a private member of an instance from
+// outside the methods of its class and the classes it inherits from.
+func (i *Interpreter) checkPrivate(object any, name token.Token) error {
+	instance, ok := object.(*LoxInstance)
+	if !ok || !strings.HasPrefix(name.Lexeme, "_") || (i.currentClass != nil && instance.class.isA(i.currentClass)) {
+		return nil
+	}
+	return logger.InterpreterErrorWithLineNumber(name, "Cannot access private member '"+name.Lexeme+"' outside class '"+instance.class.name+"'.")
+}
+
+// property looks up a named property of a value.
+func property(object any, name token.Token) (any, error) {
+	if instance, ok := object.(*LoxInstance); ok {
+		return instance.get(name)
+	}
+	if module, ok := object.(*Module); ok {
+		return module.get(name)
+	}
+	if enum, ok := object.(*LoxEnum); ok {
+		return enum.get(name)
+	}
+	return nil, logger.InterpreterErrorWithLineNumber(name, "Only instances, modules and enums have properties.")
+}
+
+// Bind a resource for the duration of the body, then call its close method
+// however the body finishes. An error from the body takes precedence over
+// one from close.
+func (i *Interpreter) withStmt(expr ast.Expr) (any, error) {
+	withStmt := expr.(*ast.With)
+	resource, err := i.evaluate(withStmt.Initializer)
+	if err != nil {
+		return nil, err
+	}
+	previousEnvironment := i.environment
+	i.environment = environment.NewEnclosed(previousEnvironment)
+	if !isDiscard(withStmt.Name) {
+		i.environment.Define(withStmt.Name.Lexeme, resource)
+	}
+	_, err = i.evaluate(withStmt.Body)
+	i.environment = previousEnvironment
+	closeErr := i.closeResource(withStmt.Keyword, resource)
+	if err == nil || (closeErr != nil && isControlFlow(err)) {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// closeResource calls the close method of a value bound by a with statement.
+func (i *Interpreter) closeResource(keyword token.Token, resource any) error {
+	closeName := token.Token{Type: token.IDENTIFIER, Lexeme: "close", Line: keyword.Line}
+	method, err := property(resource, closeName)
+	if err != nil {
+		return logger.InterpreterErrorWithLineNumber(keyword, "Resource bound by 'with' has no 'close' method.")
+	}
+	closer, ok := method.(Callable)
+	if !ok || (closer.Arity() != 0 && closer.Arity() != variadic) {
+		return logger.InterpreterErrorWithLineNumber(keyword, "Resource's 'close' must be a function that takes no arguments.")
+	}
+	_, err = closer.Call(i, []any{})
+	return err
+}
+
+// isControlFlow reports whether err is a return, break or continue
+// statement unwinding, rather than a real error.
+func isControlFlow(err error) bool {
+	switch err.(type) {
+	case *returnValue, *loopSignal:
+		return true
+	}
+	return false
+}
+
+// Run another script. A bare import runs the script in the current scope, so
+// its definitions become visible to the importer. An aliased import runs it in
+// its own environment and binds the resulting module to the alias.
+func (i *Interpreter) importStmt(expr ast.Expr) (any, error) {
+	importStmt := expr.(*ast.Import)
+	path := importStmt.Path.Literal.(string)
+	if !i.AllowFileIO {
+		return nil, logger.InterpreterErrorWithLineNumber(importStmt.Keyword, "Imports are not available when file access is disabled.")
+	}
+	// A relative path is relative to the importing script, not to wherever
+	// golox was started.
+	cleanPath := filepath.Clean(path)
+	if !filepath.IsAbs(cleanPath) {
+		cleanPath = filepath.Join(i.ScriptDir, cleanPath)
+	}
+	// A module that imports itself, directly or not, would never finish.
+	if i.importing[cleanPath] {
+		return nil, logger.InterpreterErrorWithLineNumber(importStmt.Keyword, "Circular import of '"+path+"'.")
+	}
+	if i.importing == nil {
+		i.importing = map[string]bool{}
+	}
+	i.importing[cleanPath] = true
+	defer delete(i.importing, cleanPath)
+	source, err := os.ReadFile(cleanPath)
+	if err != nil {
+		return nil, logger.InterpreterErrorWithLineNumber(importStmt.Keyword, "Could not read module '"+path+"'.")
+	}
+	// The module's own imports are relative to the module.
+	previousDir := i.ScriptDir
+	i.ScriptDir = filepath.Dir(cleanPath)
+	defer func() { i.ScriptDir = previousDir }()
+	tokens, errors := scanner.Tokenize(string(source))
+	if len(errors) > 0 {
+		return nil, errors[0]
+	}
+	parser := parser.New(tokens)
+	statements, errors := parser.Parse()
+	if len(errors) > 0 {
+		return nil, errors[0]
+	}
+	// While the module runs, __name__ is its path instead of "__main__", so
+	// it can tell it is being imported.
+	previousName := i.globals.Values["__name__"]
+	i.globals.Define("__name__", path)
+	defer i.globals.Define("__name__", previousName)
+	if importStmt.Alias == nil {
+		for _, statement := range statements {
+			_, err := i.evaluate(statement)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	}
+	module := &Module{name: importStmt.Alias.Lexeme, environment: environment.NewEnclosed(i.globals)}
+	module.environment.Define("__name__", path)
+	previousEnvironment := i.environment
+	i.environment = module.environment
+	for _, statement := range statements {
+		_, err := i.evaluate(statement)
+		if err != nil {
+			i.environment = previousEnvironment
+			return nil, err
+		}
+	}
+	i.environment = previousEnvironment
+	i.environment.Define(importStmt.Alias.Lexeme, module)
+	return nil, nil
+}
+
 func (i *Interpreter) variableExpr(expr ast.Expr) (any, error) {
 	variableExpr := expr.(*ast.Variable)
 	v, err := i.environment.Get(variableExpr.Name)
@@ -458,6 +1473,12 @@ func isTruthy(value any) bool {
 	return true
 }
 
+// isDiscard reports whether name is the throwaway variable _, which can be
+// bound any number of times but is never stored.
+func isDiscard(name token.Token) bool {
+	return name.Lexeme == "_"
+}
+
 func isEqual(a any, b any) bool {
 	// Nil is only equal to nil.
 	if a == nil && b == nil {
@@ -467,33 +1488,120 @@ func isEqual(a any, b any) bool {
 	if a == nil {
 		return false
 	}
+	// Rationals are compared by value rather than by pointer.
+	if ra, ok := a.(*big.Rat); ok {
+		if rb, ok := b.(*big.Rat); ok {
+			return ra.Cmp(rb) == 0
+		}
+		if f, ok := b.(float64); ok {
+			rb, ok := floatToRat(f)
+			return ok && ra.Cmp(rb) == 0
+		}
+	}
+	if f, ok := a.(float64); ok {
+		if rb, ok := b.(*big.Rat); ok {
+			ra, ok := floatToRat(f)
+			return ok && ra.Cmp(rb) == 0
+		}
+	}
 	// If they're both numbers, compare them.
 	return a == b
 }
 
+// compareStrings applies a comparison operator to two strings, ordering them
+// lexicographically by byte. It returns false if the operands aren't both
+// strings or the operator isn't a comparison.
+func compareStrings(operator token.Token, left any, right any) (bool, bool) {
+	leftString, ok := left.(string)
+	if !ok {
+		return false, false
+	}
+	rightString, ok := right.(string)
+	if !ok {
+		return false, false
+	}
+	switch operator.Type {
+	case token.GREATER:
+		return leftString > rightString, true
+	case token.GREATER_EQUAL:
+		return leftString >= rightString, true
+	case token.LESS:
+		return leftString < rightString, true
+	case token.LESS_EQUAL:
+		return leftString <= rightString, true
+	}
+	return false, false
+}
+
+// Numbers are always float64, apart from the rationals of BigNumbers mode,
+// which are dealt with before these checks. Natives must return float64 too.
 func checkNumberOperand(operator token.Token, operand any) error {
-	switch operand.(type) {
-	case int, float64:
+	if _, ok := operand.(float64); ok {
 		return nil
 	}
 	return logger.InterpreterErrorWithLineNumber(operator, "Operand must be a number.")
 }
 
 func checkNumberOperands(operator token.Token, left any, right any) error {
-	switch left.(type) {
-	case int, float64:
-		switch right.(type) {
-		case int, float64:
-			return nil
-		}
+	if _, ok := left.(float64); !ok {
+		return logger.InterpreterErrorWithLineNumber(operator, "Left operand must be a number.")
+	}
+	if _, ok := right.(float64); !ok {
 		return logger.InterpreterErrorWithLineNumber(operator, "Right operand must be a number.")
 	}
-	return logger.InterpreterErrorWithLineNumber(operator, "Left operand must be a number.")
+	return nil
 }
 
-func stringify(value any) string {
-	if value == nil {
+// DefaultMaxFormatDepth is the MaxFormatDepth of a new interpreter.
+const DefaultMaxFormatDepth = 100
+
+func (i *Interpreter) stringify(value any) string {
+	return format(value, false, map[*LoxArray]bool{}, 0, i.MaxFormatDepth)
+}
+
+// repr is like stringify, but shows what kind of value it is: strings are
+// quoted and functions are shown in angle brackets.
+func (i *Interpreter) repr(value any) string {
+	return format(value, true, map[*LoxArray]bool{}, 0, i.MaxFormatDepth)
+}
+
+// format renders a value for stringify or repr. An array that contains
+// itself, directly or not, is shown as [...] where it recurs, and so is one
+// nested maxDepth arrays deep.
+func format(value any, quote bool, visiting map[*LoxArray]bool, depth int, maxDepth int) string {
+	switch v := value.(type) {
+	case nil:
 		return "nil"
+	case float64:
+		// Whole numbers print without a fractional part or exponent, like
+		// 100000000 rather than 1e+08.
+		if v == math.Trunc(v) && math.Abs(v) < 1e21 {
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case *big.Rat:
+		return formatRat(v)
+	case *LoxArray:
+		if visiting[v] || depth >= maxDepth {
+			return "[...]"
+		}
+		visiting[v] = true
+		defer delete(visiting, v)
+		elements := make([]string, len(v.Elements))
+		for i, element := range v.Elements {
+			elements[i] = format(element, quote, visiting, depth+1, maxDepth)
+		}
+		return "[" + strings.Join(elements, ", ") + "]"
+	}
+	if quote {
+		switch v := value.(type) {
+		case string:
+			return "\"" + v + "\""
+		case Function:
+			return "<fn " + v.declaration.Name.Lexeme + ">"
+		case NativeFunction:
+			return "<native fn>"
+		}
 	}
 	return fmt.Sprintf("%v", value)
 }
@@ -0,0 +1,2231 @@
+package interpreter
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lowercasename/golox/ast"
+	"github.com/lowercasename/golox/parser"
+	"github.com/lowercasename/golox/scanner"
+	"github.com/lowercasename/golox/token"
+)
+
+// run scans, parses and evaluates source, stopping at the first parse or
+// runtime error. It returns the value of the last statement evaluated.
+func run(i *Interpreter, source string) (any, error) {
+	scanner := scanner.New(source)
+	parser := parser.New(scanner.ScanTokens())
+	statements, errors := parser.Parse()
+	if len(errors) > 0 {
+		return nil, errors[0]
+	}
+	var v any
+	for _, statement := range statements {
+		var err error
+		v, err = i.evaluate(statement)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// parse scans and parses source, failing the test if it doesn't parse.
+func parse(t testing.TB, source string) []ast.Expr {
+	t.Helper()
+	scanner := scanner.New(source)
+	parser := parser.New(scanner.ScanTokens())
+	statements, errors := parser.Parse()
+	if len(errors) > 0 {
+		t.Fatalf("expected %q to parse, got=%q", source, errors)
+	}
+	return statements
+}
+
+// lookup reads a variable visible from the interpreter's current scope.
+func lookup(t *testing.T, i *Interpreter, name string) any {
+	t.Helper()
+	v, err := i.environment.Get(token.Token{Type: token.IDENTIFIER, Lexeme: name})
+	if err != nil {
+		t.Fatalf("expected variable %s to be defined, got=%q", name, err)
+	}
+	return v
+}
+
+func TestImportAs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "math.lox")
+	module := "var answer = 42;\nfun double(x) { var doubled = x * 2; }\n"
+	if err := os.WriteFile(path, []byte(module), 0644); err != nil {
+		t.Fatal(err)
+	}
+	i := New()
+	_, err := run(i, "import \""+path+"\" as m;\nvar a = m.answer;\nm.double(2);")
+	if err != nil {
+		t.Fatalf("expected no error, got=%q", err)
+	}
+	if lookup(t, i, "a") != 42.0 {
+		t.Fatalf("expected=42, got=%v", lookup(t, i, "a"))
+	}
+	// The module's definitions must not leak into the importing scope.
+	if _, err := run(i, "answer;"); err == nil {
+		t.Fatalf("expected module definitions to stay inside the module")
+	}
+	if _, err := run(i, "m.missing;"); err == nil {
+		t.Fatalf("expected error accessing an undefined module member")
+	}
+}
+
+func TestRelativeImport(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "sub", "nested"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		// A module's imports are relative to the module, not the importer.
+		filepath.Join(dir, "sub", "lib.lox"):            "import \"nested/deep.lox\" as deep;\nvar x = deep.y + 1;\n",
+		filepath.Join(dir, "sub", "nested", "deep.lox"): "var y = 41;\n",
+	}
+	for path, source := range files {
+		if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	i := New()
+	i.ScriptDir = filepath.Join(dir, "sub")
+	v, err := run(i, "import \"lib.lox\" as l;\nl.x;")
+	if err != nil || v != 42.0 {
+		t.Fatalf("expected=42, got=%v (%v)", v, err)
+	}
+	if i.ScriptDir != filepath.Join(dir, "sub") {
+		t.Fatalf("expected ScriptDir to be restored, got=%q", i.ScriptDir)
+	}
+	// Without a script directory, paths are relative to the working directory
+	_, err = run(New(), "import \"lib.lox\";")
+	if err == nil || err.Error() != "[line 1] RuntimeError at 'import': Could not read module 'lib.lox'.\n" {
+		t.Fatalf("expected a read error, got=%v", err)
+	}
+}
+
+func TestCircularImport(t *testing.T) {
+	dir := t.TempDir()
+	self := filepath.Join(dir, "self.lox")
+	a := filepath.Join(dir, "a.lox")
+	b := filepath.Join(dir, "b.lox")
+	files := map[string]string{
+		self: "import \"" + self + "\";\n",
+		// The same file written differently is still the same module.
+		a: "import \"" + dir + "/./b.lox\";\n",
+		b: "var fromB = 1;\nimport \"" + a + "\" as again;\n",
+	}
+	for path, source := range files {
+		if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	errors := map[string]string{
+		self: "[line 1] RuntimeError at 'import': Circular import of '" + self + "'.\n",
+		a:    "[line 2] RuntimeError at 'import': Circular import of '" + a + "'.\n",
+	}
+	for path, expected := range errors {
+		_, err := run(New(), "import \""+path+"\";")
+		if err == nil || err.Error() != expected {
+			t.Fatalf("expected=%q, got=%v", expected, err)
+		}
+	}
+
+	// A module can be imported again once its first import has finished.
+	path := filepath.Join(dir, "plain.lox")
+	if err := os.WriteFile(path, []byte("var x = 1;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	v, err := run(New(), "import \""+path+"\" as p;\nimport \""+path+"\" as q;\np.x + q.x;")
+	if err != nil || v != 2.0 {
+		t.Fatalf("expected a module to be importable twice, got=%v (%v)", v, err)
+	}
+}
+
+func TestNilPropagation(t *testing.T) {
+	i := New()
+	if _, err := run(i, "nil + 1;"); err == nil {
+		t.Fatalf("expected error adding nil to a number by default")
+	}
+	i.NilPropagation = true
+	for _, source := range []string{"nil + 1;", "2 * nil;", "-nil;", "(nil - 1) / 2;"} {
+		v, err := run(i, source)
+		if err != nil {
+			t.Fatalf("expected no error for %s, got=%q", source, err)
+		}
+		if v != nil {
+			t.Fatalf("expected=nil for %s, got=%v", source, v)
+		}
+	}
+	if _, err := run(i, "\"a\" - 1;"); err == nil {
+		t.Fatalf("expected other type errors to remain")
+	}
+}
+
+func TestDivisionByZeroMessage(t *testing.T) {
+	i := New()
+	_, err := run(i, "1 / 0;")
+	expected := "[line 1] RuntimeError at '/': Division by zero.\n"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected=%q, got=%q", expected, err)
+	}
+	i.Messages = FunMessages
+	_, err = run(i, "1 / 0;")
+	expected = "[line 1] RuntimeError at '/': Division by zero. Eldritch horrors invoked.\n"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected=%q, got=%q", expected, err)
+	}
+}
+
+func TestChars(t *testing.T) {
+	i := New()
+	v, err := run(i, "chars(\"héllo\");")
+	if err != nil {
+		t.Fatalf("expected no error, got=%q", err)
+	}
+	array, ok := v.(*LoxArray)
+	if !ok {
+		t.Fatalf("expected an array, got=%T", v)
+	}
+	expected := []string{"h", "é", "l", "l", "o"}
+	if len(array.Elements) != len(expected) {
+		t.Fatalf("expected=%d elements, got=%d", len(expected), len(array.Elements))
+	}
+	for n, element := range array.Elements {
+		if element != expected[n] {
+			t.Fatalf("expected=%q, got=%q", expected[n], element)
+		}
+	}
+	if i.stringify(array) != "[h, é, l, l, o]" {
+		t.Fatalf("expected=[h, é, l, l, o], got=%q", i.stringify(array))
+	}
+	v, _ = run(i, "join(chars(\"héllo\"), \"\");")
+	if v != "héllo" {
+		t.Fatalf("expected=héllo, got=%v", v)
+	}
+	v, _ = run(i, "chars(\"\");")
+	if len(v.(*LoxArray).Elements) != 0 {
+		t.Fatalf("expected an empty array, got=%v", v)
+	}
+	if _, err := run(i, "chars(1);"); err == nil {
+		t.Fatalf("expected error for a non-string argument")
+	}
+}
+
+func TestWhereClause(t *testing.T) {
+	i := New()
+	_, err := run(i, "fun checked(n) where n >= 0 { var ok = n; }\nchecked(4);")
+	if err != nil {
+		t.Fatalf("expected satisfied contract to pass, got=%q", err)
+	}
+	_, err = run(i, "checked(-1);")
+	expected := "[line 1] RuntimeError at 'checked': Contract 'where n >= 0' violated.\n"
+	if err == nil || !strings.HasPrefix(err.Error(), expected) {
+		t.Fatalf("expected=%q, got=%q", expected, err)
+	}
+}
+
+func TestGetenv(t *testing.T) {
+	t.Setenv("GOLOX_TEST_VALUE", "hello")
+	i := New()
+	v, err := run(i, "getenv(\"GOLOX_TEST_VALUE\");")
+	if err != nil || v != "hello" {
+		t.Fatalf("expected=hello, got=%v (%v)", v, err)
+	}
+	v, err = run(i, "getenv(\"GOLOX_TEST_MISSING\");")
+	if err != nil || v != nil {
+		t.Fatalf("expected=nil, got=%v (%v)", v, err)
+	}
+	if _, err := run(i, "getenv(1);"); err == nil {
+		t.Fatalf("expected error for a non-string argument")
+	}
+	i.AllowFileIO = false
+	if _, err := run(i, "getenv(\"GOLOX_TEST_VALUE\");"); err == nil {
+		t.Fatalf("expected error when file access is disabled")
+	}
+	if _, err := run(i, "cwd();"); err == nil {
+		t.Fatalf("expected error when file access is disabled")
+	}
+}
+
+func TestEprint(t *testing.T) {
+	var out, errOut bytes.Buffer
+	i := New()
+	i.Out = &out
+	i.Err = &errOut
+	if _, err := run(i, "print \"to out\";\neprint(\"to err\");"); err != nil {
+		t.Fatalf("expected no error, got=%q", err)
+	}
+	if out.String() != "to out\n" {
+		t.Fatalf("expected=%q, got=%q", "to out\n", out.String())
+	}
+	if errOut.String() != "to err\n" {
+		t.Fatalf("expected=%q, got=%q", "to err\n", errOut.String())
+	}
+}
+
+func TestDiscardVariable(t *testing.T) {
+	var out bytes.Buffer
+	i := New()
+	i.Out = &out
+	_, err := run(i, "var _ = 1;\nvar _ = 2;\n_ = 3;\nfun second(_, b) { print b; }\nsecond(1, 2);")
+	if err != nil {
+		t.Fatalf("expected no error, got=%q", err)
+	}
+	if out.String() != "2\n" {
+		t.Fatalf("expected=%q, got=%q", "2\n", out.String())
+	}
+	if _, ok := i.globals.Values["_"]; ok {
+		t.Fatalf("expected _ never to be stored")
+	}
+	// Reading _ is a parse error, so the statement is dropped.
+	scanner := scanner.New("print _;")
+	parser := parser.New(scanner.ScanTokens())
+	statements, errors := parser.Parse()
+	if len(statements) != 0 || len(errors) != 1 || errors[0].Error() != "[line 1] ParserError at '_': Cannot read from '_'.\n" {
+		t.Fatalf("expected reading _ to fail to parse, got=%v %q", statements, errors)
+	}
+}
+
+func TestStackTrace(t *testing.T) {
+	var out bytes.Buffer
+	i := New()
+	i.Out = &out
+	source := `fun inner() {
+  print stackTrace();
+  1 / 0;
+}
+fun outer() {
+  inner();
+}
+outer();`
+	_, err := run(i, source)
+	expected := "[line 3] RuntimeError at '/': Division by zero.\n" +
+		"    in inner() called at line 6\n" +
+		"    in outer() called at line 8\n"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected=%q, got=%q", expected, err)
+	}
+	if out.String() != "[inner() called at line 6, outer() called at line 8]\n" {
+		t.Fatalf("expected stackTrace() to list both calls, got=%q", out.String())
+	}
+	if len(i.callStack) != 0 {
+		t.Fatalf("expected the call stack to be empty after the error, got=%v", i.callStack)
+	}
+}
+
+func TestContinueOnError(t *testing.T) {
+	var out bytes.Buffer
+	i := New()
+	i.Out = &out
+	i.ContinueOnError = true
+	err := i.Interpret(parse(t, "print 1 / 0;\nprint \"still running\";\nprint -\"a\";"))
+	if out.String() != "still running\n" {
+		t.Fatalf("expected=%q, got=%q", "still running\n", out.String())
+	}
+	if len(i.Errors()) != 2 {
+		t.Fatalf("expected=2 errors, got=%d", len(i.Errors()))
+	}
+	if err == nil || err.Error() != "[line 1] RuntimeError at '/': Division by zero.\n" {
+		t.Fatalf("expected the first error to be returned, got=%v", err)
+	}
+}
+
+func TestInterpretReturnsError(t *testing.T) {
+	var out, errOut bytes.Buffer
+	i := NewWithOutput(&out, &errOut)
+	err := i.Interpret(parse(t, "print 1;\nprint -\"a\";\nprint 2;"))
+	if err == nil || err.Error() != "[line 2] RuntimeError at '-': Operand must be a number.\n" {
+		t.Fatalf("expected operand error, got=%v", err)
+	}
+	if out.String() != "1\n" || errOut.Len() != 0 {
+		t.Fatalf("expected=%q and no error output, got=%q %q", "1\n", out.String(), errOut.String())
+	}
+	if err := i.Interpret(parse(t, "print 3;")); err != nil {
+		t.Fatalf("expected no error, got=%v", err)
+	}
+}
+
+func TestDefineNative(t *testing.T) {
+	var out bytes.Buffer
+	i := NewWithOutput(&out, io.Discard)
+	i.DefineNative("double", 1, func(args []any) (any, error) {
+		return args[0].(float64) * 2, nil
+	})
+	i.DefineNative("argc", -1, func(args []any) (any, error) {
+		return float64(len(args)), nil
+	})
+	i.DefineNative("fail", 0, func(args []any) (any, error) {
+		return nil, fmt.Errorf("host failure")
+	})
+	if err := i.Interpret(parse(t, "var result = double(21);\nprint argc(1, 2, 3);")); err != nil {
+		t.Fatalf("expected no error, got=%v", err)
+	}
+	if out.String() != "3\n" {
+		t.Fatalf("expected=%q, got=%q", "3\n", out.String())
+	}
+	if v, ok := i.Global("result"); !ok || v != 42.0 {
+		t.Fatalf("expected=42, got=%v %v", v, ok)
+	}
+	if _, ok := i.Global("missing"); ok {
+		t.Fatalf("expected missing to be undefined")
+	}
+	if err := i.Interpret(parse(t, "fail();")); err == nil || err.Error() != "host failure" {
+		t.Fatalf("expected host failure, got=%v", err)
+	}
+}
+
+func TestLen(t *testing.T) {
+	i := New()
+	tests := map[string]any{
+		"len(\"hello\");":          5.0,
+		"len(\"\");":               0.0,
+		"len(\"héllo\");":          5.0,
+		"len(\"😀!\");":             2.0,
+		"len(\"ab\" + \"héllo\");": 7.0,
+	}
+	for source, expected := range tests {
+		v, err := run(i, source)
+		if err != nil || v != expected {
+			t.Fatalf("expected=%v for %s, got=%v (%v)", expected, source, v, err)
+		}
+	}
+	_, err := run(i, "len(12);")
+	if err == nil || err.Error() != "Error: Argument to 'len' must be a string or an array.\n" {
+		t.Fatalf("expected type error, got=%v", err)
+	}
+}
+
+func TestNumAndStr(t *testing.T) {
+	i := New()
+	tests := map[string]any{
+		"num(\"42\");":           42.0,
+		"num(\" -1.5 \");":       -1.5,
+		"num(\"1e3\");":          1000.0,
+		"num(str(42)) == 42;":    true,
+		"num(str(0.1)) == 0.1;":  true,
+		"str(42);":               "42",
+		"str(1.5);":              "1.5",
+		"str(nil);":              "nil",
+		"str(true);":             "true",
+		"str(range(3));":         "[0, 1, 2]",
+		"str(\"already\");":      "already",
+		"str(42) + \" apples\";": "42 apples",
+	}
+	for source, expected := range tests {
+		v, err := run(i, source)
+		if err != nil || v != expected {
+			t.Fatalf("expected=%v for %s, got=%v (%v)", expected, source, v, err)
+		}
+	}
+	errors := map[string]string{
+		"num(\"abc\");": "Error: Cannot convert \"abc\" to a number.\n",
+		"num(\"\");":    "Error: Cannot convert \"\" to a number.\n",
+		"num(\"Inf\");": "Error: Cannot convert \"Inf\" to a number.\n",
+		"num(12);":      "Error: Argument 1 to 'num' must be a string.\n",
+	}
+	for source, expected := range errors {
+		_, err := run(i, source)
+		if err == nil || err.Error() != expected {
+			t.Fatalf("expected=%q for %s, got=%v", expected, source, err)
+		}
+	}
+}
+
+func TestReadLine(t *testing.T) {
+	var out bytes.Buffer
+	i := NewWithIO(strings.NewReader("first\r\nsecond\nlast"), &out, io.Discard)
+	if err := i.Interpret(parse(t, "var line;\nwhile ((line = readLine()) != nil) print \"<\" + line + \">\";\nprint readLine() == nil;")); err != nil {
+		t.Fatalf("expected no error, got=%v", err)
+	}
+	expected := "<first>\n<second>\n<last>\ntrue\n"
+	if out.String() != expected {
+		t.Fatalf("expected=%q, got=%q", expected, out.String())
+	}
+}
+
+func TestExit(t *testing.T) {
+	codes := []int{}
+	exit = func(code int) { codes = append(codes, code) }
+	defer func() { exit = os.Exit }()
+
+	var out bytes.Buffer
+	i := New()
+	buffered := bufio.NewWriter(&out)
+	i.Out = buffered
+	if _, err := run(i, "print \"bye\";\nexit(3);\nexit();"); err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 2 || codes[0] != 3 || codes[1] != 0 {
+		t.Fatalf("expected exit codes [3 0], got=%v", codes)
+	}
+	if out.String() != "bye\n" {
+		t.Fatalf("expected output to be flushed before exiting, got=%q", out.String())
+	}
+
+	errors := map[string]string{
+		"exit(\"x\");": "Error: Argument 1 to 'exit' must be a number.\n",
+		"exit(1.5);":   "Error: Argument 1 to 'exit' must be a whole number.\n",
+		"exit(256);":   "Error: Exit code passed to 'exit' must be between 0 and 255.\n",
+		"exit(1, 2);":  "Error: Expected 0 to 1 arguments to 'exit' but got 2.\n",
+	}
+	for source, expected := range errors {
+		_, err := run(New(), source)
+		if err == nil || err.Error() != expected {
+			t.Fatalf("expected=%q for %s, got=%v", expected, source, err)
+		}
+	}
+	if len(codes) != 2 {
+		t.Fatalf("expected invalid calls not to exit, got=%v", codes)
+	}
+}
+
+func TestInput(t *testing.T) {
+	var out bytes.Buffer
+	i := NewWithIO(strings.NewReader("Ada\n"), &out, io.Discard)
+	if err := i.Interpret(parse(t, "var name = input(\"Name: \");\nprint \"Hello, \" + name + \"!\";\nprint input(\"Again: \") == nil;")); err != nil {
+		t.Fatalf("expected no error, got=%v", err)
+	}
+	expected := "Name: Hello, Ada!\nAgain: true\n"
+	if out.String() != expected {
+		t.Fatalf("expected=%q, got=%q", expected, out.String())
+	}
+	expectedError := "Error: Argument 1 to 'input' must be a string.\n"
+	if _, err := run(i, "input(1);"); err == nil || err.Error() != expectedError {
+		t.Fatalf("expected=%q, got=%v", expectedError, err)
+	}
+}
+
+func TestNumberLiterals(t *testing.T) {
+	i := New()
+	for _, source := range []string{"1e3 == 1000;", "0x10 == 16;", "2.5e-3 * 4 == 0.01;", "0xff + 1 == 256;", "1_000 == 1000;"} {
+		v, err := run(i, source)
+		if err != nil || v != true {
+			t.Fatalf("expected %s to be true, got=%v (%v)", source, v, err)
+		}
+	}
+}
+
+func TestCompoundAssignment(t *testing.T) {
+	tests := map[string]string{
+		"var x = 1; x += 4; print x;":                                             "5\n",
+		"var x = 10; x -= 4; x *= 3; x /= 2; print x;":                            "9\n",
+		"var s = \"a\"; s += \"b\"; print s;":                                     "ab\n",
+		"var x = 1; var y = 2; x += y += 3; print x; print y;":                    "6\n5\n",
+		"var total = 0; for (var i = 1; i <= 4; i += 1) total += i; print total;": "10\n",
+		"var x = 2; fun f() { x *= 5; } f(); print x;":                            "10\n",
+	}
+	for source, expected := range tests {
+		var out bytes.Buffer
+		i := NewWithOutput(&out, io.Discard)
+		if _, err := run(i, source); err != nil {
+			t.Fatalf("expected no error for %s, got=%v", source, err)
+		}
+		if out.String() != expected {
+			t.Fatalf("expected=%q for %s, got=%q", expected, source, out.String())
+		}
+	}
+	_, err := run(New(), "var x = \"a\";\nx -= 1;")
+	if err == nil || !strings.HasPrefix(err.Error(), "[line 2] RuntimeError at '-': ") {
+		t.Fatalf("expected an operand error at '-', got=%v", err)
+	}
+	_, err = run(New(), "var p = 1;\np.x += 1;")
+	if err == nil || err.Error() != "[line 2] ParserError at '+=': Invalid assignment target.\n" {
+		t.Fatalf("expected invalid target error, got=%v", err)
+	}
+}
+
+func TestLists(t *testing.T) {
+	i := New()
+	if _, err := run(i, "var list = [1, \"two\", [3, 4], nil,];\nvar empty = [];"); err != nil {
+		t.Fatalf("expected no error, got=%v", err)
+	}
+	tests := map[string]string{
+		"list;":                        "[1, two, [3, 4], nil]",
+		"empty;":                       "[]",
+		"list[0];":                     "1",
+		"list[2][1];":                  "4",
+		"len([1, 2, 3]);":              "3",
+		"len(empty);":                  "0",
+		"[1 + 1, list[0]];":            "[2, 1]",
+		"equals([0, 1, 2], range(3));": "true",
+	}
+	for source, expected := range tests {
+		v, err := run(i, source)
+		if err != nil || i.stringify(v) != expected {
+			t.Fatalf("expected=%v for %s, got=%v (%v)", expected, source, v, err)
+		}
+	}
+	v, err := run(i, "var grid = [[0, 0], [0, 0]];\ngrid[1][0] = 5;\nlist[1] = list[0] = 9;\n[grid, list[0], list[1]];")
+	if err != nil || i.stringify(v) != "[[[0, 0], [5, 0]], 9, 9]" {
+		t.Fatalf("expected assignment through indices, got=%v (%v)", v, err)
+	}
+	errors := map[string]string{
+		"list[4];":          "[line 1] RuntimeError at '[': Index 4 is out of bounds for an array of length 4.\n",
+		"list[-1];":         "[line 1] RuntimeError at '[': Index -1 is out of bounds for an array of length 4.\n",
+		"list[1.5];":        "[line 1] RuntimeError at '[': Index must be a whole number.\n",
+		"list[\"0\"];":      "[line 1] RuntimeError at '[': Index must be a whole number.\n",
+		"empty[0] = 1;":     "[line 1] RuntimeError at '[': Index 0 is out of bounds for an array of length 0.\n",
+		"var n = 1;\nn[0];": "[line 2] RuntimeError at '[': Only arrays and strings can be indexed.\n",
+	}
+	for source, expected := range errors {
+		_, err := run(i, source)
+		if err == nil || err.Error() != expected {
+			t.Fatalf("expected=%q for %s, got=%v", expected, source, err)
+		}
+	}
+}
+
+func TestStringIndexing(t *testing.T) {
+	i := New()
+	tests := map[string]string{
+		"\"hello\"[0];":                  "h",
+		"\"hello\"[4];":                  "o",
+		"\"héllo\"[1];":                  "é",
+		"\"日本語\"[2];":                    "語",
+		"var s = \"añb\";\ns[1] + s[2];": "ñb",
+		"substr(\"hello\", 1, 3);":       "el",
+		"substr(\"hello\", 0, 5);":       "hello",
+		"substr(\"hello\", 2, 2);":       "",
+		"substr(\"日本語です\", 1, 3);":       "本語",
+		"substr(\"naïve\", 2, 5);":       "ïve",
+	}
+	for source, expected := range tests {
+		v, err := run(i, source)
+		if err != nil || v != expected {
+			t.Fatalf("expected=%q for %s, got=%v (%v)", expected, source, v, err)
+		}
+	}
+	errors := map[string]string{
+		"\"héllo\"[5];":                   "[line 1] RuntimeError at '[': Index 5 is out of bounds for a string of length 5.\n",
+		"\"abc\"[-1];":                    "[line 1] RuntimeError at '[': Index -1 is out of bounds for a string of length 3.\n",
+		"\"abc\"[0.5];":                   "[line 1] RuntimeError at '[': Index must be a whole number.\n",
+		"var s = \"abc\";\ns[0] = \"x\";": "[line 2] RuntimeError at '[': Cannot assign to a character of a string.\n",
+		"var n = 1;\nn[0] = 2;":           "[line 2] RuntimeError at '[': Only arrays can be assigned to by index.\n",
+		"substr(\"日本語\", 1, 4);":          "Error: Range 1 to 4 passed to 'substr' is out of bounds for a string of length 3.\n",
+		"substr(\"abc\", 2, 1);":          "Error: Range 2 to 1 passed to 'substr' is out of bounds for a string of length 3.\n",
+		"substr(\"abc\", 0.5, 1);":        "Error: Argument 2 to 'substr' must be a whole number.\n",
+		"substr(1, 0, 1);":                "Error: Argument 1 to 'substr' must be a string.\n",
+	}
+	for source, expected := range errors {
+		_, err := run(i, source)
+		if err == nil || err.Error() != expected {
+			t.Fatalf("expected=%q for %s, got=%v", expected, source, err)
+		}
+	}
+}
+
+func TestStringComparison(t *testing.T) {
+	i := New()
+	tests := map[string]bool{
+		"\"apple\" < \"banana\";":  true,
+		"\"apple\" > \"banana\";":  false,
+		"\"apple\" <= \"apple\";":  true,
+		"\"apple\" >= \"apples\";": false,
+		"\"Zebra\" < \"apple\";":   true,
+		"\"\" < \"a\";":            true,
+		"\"10\" < \"9\";":          true,
+		"10 < 9;":                  false,
+	}
+	for source, expected := range tests {
+		v, err := run(i, source)
+		if err != nil || v != expected {
+			t.Fatalf("expected=%v for %s, got=%v (%v)", expected, source, v, err)
+		}
+	}
+	for _, source := range []string{"\"a\" < 1;", "1 >= \"a\";", "nil < \"a\";"} {
+		if _, err := run(i, source); err == nil || !strings.Contains(err.Error(), "operand must be a number.") {
+			t.Fatalf("expected an operand error for %s, got=%v", source, err)
+		}
+	}
+}
+
+func TestCallNonFunction(t *testing.T) {
+	errors := map[string]string{
+		"var x = 3;\nx();":           "[line 2] RuntimeError at ')': Can only call functions and classes. Tried to call a number.\n",
+		"\"text\"(1);":               "[line 1] RuntimeError at ')': Can only call functions and classes. Tried to call a string.\n",
+		"[1, 2]();":                  "[line 1] RuntimeError at ')': Can only call functions and classes. Tried to call an array.\n",
+		"var n;\n\nn = nil;\nnil();": "[line 4] RuntimeError at ')': Can only call functions and classes. Tried to call nil.\n",
+	}
+	for source, expected := range errors {
+		_, err := run(New(), source)
+		if err == nil || err.Error() != expected {
+			t.Fatalf("expected=%q for %s, got=%v", expected, source, err)
+		}
+	}
+}
+
+func TestUninitialized(t *testing.T) {
+	values := map[string]any{
+		"var x = nil;\nx;":                         nil,
+		"var x;\nx = nil;\nx;":                     nil,
+		"var x;\nx = 1;\nx;":                       1.0,
+		"fun f() { var x = nil; return x; }\nf();": nil,
+	}
+	for source, expected := range values {
+		v, err := run(New(), source)
+		if err != nil || v != expected {
+			t.Fatalf("expected=%v for %q, got=%v (%v)", expected, source, v, err)
+		}
+	}
+
+	var out bytes.Buffer
+	i := NewWithOutput(&out, &out)
+	if _, err := run(i, "var x = nil;\nprint x;"); err != nil || out.String() != "nil\n" {
+		t.Fatalf("expected=%q, got=%q (%v)", "nil\n", out.String(), err)
+	}
+
+	errors := map[string]string{
+		"var x;\nx;":                 "[line 2] RuntimeError at 'x': Variable 'x' used before being initialized.\n",
+		"{\n  var y;\n  print y;\n}": "[line 3] RuntimeError at 'y': Variable 'y' used before being initialized.\n",
+	}
+	for source, expected := range errors {
+		_, err := run(New(), source)
+		if err == nil || err.Error() != expected {
+			t.Fatalf("expected=%q for %q, got=%v", expected, source, err)
+		}
+	}
+
+	i = New()
+	if _, err := run(i, "var x;"); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := i.Global("x"); !ok || v != nil {
+		t.Fatalf("expected an uninitialized global to be nil, got=%v %v", v, ok)
+	}
+	if _, err := run(i, "lazy const y = 1 + 1;"); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := i.Global("y"); !ok || v != 2.0 {
+		t.Fatalf("expected a lazy global to be computed, got=%v %v", v, ok)
+	}
+}
+
+func TestToExponential(t *testing.T) {
+	i := New()
+	tests := map[string]string{
+		"toExponential(12345, 2);":   "1.23e+04",
+		"toExponential(-12345, 1);":  "-1.2e+04",
+		"toExponential(0.00042, 3);": "4.200e-04",
+		"toExponential(1, 0);":       "1e+00",
+		"toExponential(1, 100);":     "1." + strings.Repeat("0", 100) + "e+00",
+	}
+	for source, expected := range tests {
+		v, err := run(i, source)
+		if err != nil || v != expected {
+			t.Fatalf("expected=%q for %s, got=%v (%v)", expected, source, v, err)
+		}
+	}
+	for _, source := range []string{"toExponential(\"1\", 2);", "toExponential(1, 1.5);"} {
+		if _, err := run(i, source); err == nil {
+			t.Fatalf("expected error for %s", source)
+		}
+	}
+	for _, source := range []string{"toExponential(1, -1);", "toExponential(1, 101);", "toExponential(1, 1e12);"} {
+		_, err := run(i, source)
+		if err == nil || err.Error() != "Error: Argument 2 to 'toExponential' must be between 0 and 100.\n" {
+			t.Fatalf("expected a range error for %s, got=%v", source, err)
+		}
+	}
+}
+
+func TestDefer(t *testing.T) {
+	var out bytes.Buffer
+	i := New()
+	i.Out = &out
+	source := `fun say(message) { print message; }
+fun work() {
+  defer say("first");
+  defer say("second");
+  print "body";
+}
+work();`
+	if _, err := run(i, source); err != nil {
+		t.Fatalf("expected no error, got=%q", err)
+	}
+	if out.String() != "body\nsecond\nfirst\n" {
+		t.Fatalf("expected=%q, got=%q", "body\nsecond\nfirst\n", out.String())
+	}
+
+	out.Reset()
+	source = `fun fail() {
+  defer say("cleanup");
+  1 / 0;
+  print "unreachable";
+}
+fail();`
+	if _, err := run(i, source); err == nil {
+		t.Fatalf("expected the body's error to propagate")
+	}
+	if out.String() != "cleanup\n" {
+		t.Fatalf("expected=%q, got=%q", "cleanup\n", out.String())
+	}
+}
+
+// array builds a LoxArray from Go values.
+func array(elements ...any) *LoxArray {
+	return &LoxArray{Elements: elements}
+}
+
+func TestSort(t *testing.T) {
+	i := New()
+	i.globals.Define("numbers", array(3.0, 1.0, 2.0))
+	i.globals.Define("words", array("pear", "apple", "fig"))
+	i.globals.Define("mixed", array(1.0, "a"))
+	i.globals.Define("descending", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			return arguments[1].(float64) - arguments[0].(float64), nil
+		},
+		arity: 2,
+	})
+	tests := map[string]string{
+		"sort(numbers);":             "[1, 2, 3]",
+		"sort(words);":               "[apple, fig, pear]",
+		"sort(numbers, descending);": "[3, 2, 1]",
+	}
+	for source, expected := range tests {
+		v, err := run(i, source)
+		if err != nil || i.stringify(v) != expected {
+			t.Fatalf("expected=%s for %s, got=%v (%v)", expected, source, i.stringify(v), err)
+		}
+	}
+	// The original array is left untouched.
+	if i.stringify(lookup(t, i, "numbers")) != "[3, 1, 2]" {
+		t.Fatalf("expected sort not to mutate its argument, got=%v", i.stringify(lookup(t, i, "numbers")))
+	}
+	if _, err := run(i, "sort(mixed);"); err == nil {
+		t.Fatalf("expected error sorting a mixed array without a comparator")
+	}
+}
+
+func TestReduce(t *testing.T) {
+	i := New()
+	i.globals.Define("numbers", array(1.0, 2.0, 3.0, 4.0))
+	i.globals.Define("empty", array())
+	i.globals.Define("add", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			return arguments[0].(float64) + arguments[1].(float64), nil
+		},
+		arity: 2,
+	})
+	v, err := run(i, "reduce(numbers, add, 0);")
+	if err != nil || v != 10.0 {
+		t.Fatalf("expected=10, got=%v (%v)", v, err)
+	}
+	v, err = run(i, "reduce(empty, add, \"initial\");")
+	if err != nil || v != "initial" {
+		t.Fatalf("expected=initial, got=%v (%v)", v, err)
+	}
+	if _, err := run(i, "reduce(numbers, sqrt, 0);"); err == nil {
+		t.Fatalf("expected error for a callback of the wrong arity")
+	}
+}
+
+func TestRange(t *testing.T) {
+	i := New()
+	tests := map[string]string{
+		"range(3);":        "[0, 1, 2]",
+		"range(2, 5);":     "[2, 3, 4]",
+		"range(0, 10, 2);": "[0, 2, 4, 6, 8]",
+		"range(5, 2);":     "[]",
+		"range(0);":        "[]",
+	}
+	for source, expected := range tests {
+		v, err := run(i, source)
+		if err != nil || i.stringify(v) != expected {
+			t.Fatalf("expected=%s for %s, got=%v (%v)", expected, source, i.stringify(v), err)
+		}
+	}
+	for _, source := range []string{"range(0, 10, 0);", "range(0, 10, -1);", "range(1.5);", "range();"} {
+		if _, err := run(i, source); err == nil {
+			t.Fatalf("expected error for %s", source)
+		}
+	}
+}
+
+func BenchmarkNumericLoop(b *testing.B) {
+	statements := parse(b, "var a = 0;\nwhile (a < 1000) {\n  a = a + ((2 * 3) - (1 + 4));\n}")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		i := New()
+		for _, statement := range statements {
+			if _, err := i.evaluate(statement); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestReplace(t *testing.T) {
+	i := New()
+	tests := map[string]string{
+		"replace(\"a-b-c\", \"-\", \"+\");":     "a+b+c",
+		"replaceN(\"a-b-c\", \"-\", \"+\", 1);": "a+b-c",
+		"replaceN(\"a-b-c\", \"-\", \"+\", 0);": "a-b-c",
+		"replace(\"banana\", \"an\", \"\");":    "ba",
+	}
+	for source, expected := range tests {
+		v, err := run(i, source)
+		if err != nil || v != expected {
+			t.Fatalf("expected=%q for %s, got=%v (%v)", expected, source, v, err)
+		}
+	}
+	for _, source := range []string{"replace(\"abc\", \"\", \"x\");", "replace(1, \"a\", \"b\");", "replaceN(\"abc\", \"a\", \"b\", -1);"} {
+		if _, err := run(i, source); err == nil {
+			t.Fatalf("expected error for %s", source)
+		}
+	}
+}
+
+func TestPatterns(t *testing.T) {
+	i := New()
+	v, err := run(i, "matches(\"golox 0.1.0\", \"[0-9]+[.][0-9]+\");")
+	if err != nil || v != true {
+		t.Fatalf("expected=true, got=%v (%v)", v, err)
+	}
+	v, err = run(i, "matches(\"golox\", \"^[0-9]+$\");")
+	if err != nil || v != false {
+		t.Fatalf("expected=false, got=%v (%v)", v, err)
+	}
+	v, err = run(i, "findAll(\"a1 b22 c333\", \"[0-9]+\");")
+	if err != nil || i.stringify(v) != "[1, 22, 333]" {
+		t.Fatalf("expected=[1, 22, 333], got=%v (%v)", i.stringify(v), err)
+	}
+	if _, err := run(i, "matches(\"abc\", \"(\");"); err == nil {
+		t.Fatalf("expected error for an invalid pattern")
+	}
+}
+
+func TestIntegerNatives(t *testing.T) {
+	i := New()
+	tests := map[string]float64{
+		"bitCount(7);":              3,
+		"bitCount(0);":              0,
+		"bitCount(1024);":           1,
+		"gcd(12, 18);":              6,
+		"gcd(-12, 18);":             6,
+		"gcd(7, 0);":                7,
+		"lcm(4, 6);":                12,
+		"lcm(0, 6);":                0,
+		"lcm(-4, 6);":               12,
+		"lcm(4, -6);":               12,
+		"lcm(-4, -6);":              12,
+		"bitCount(1e19);":           19,
+		"gcd(9007199254740992, 4);": 4,
+		"lcm(9007199254740992, 3);": 27021597764222976,
+	}
+	for source, expected := range tests {
+		v, err := run(i, source)
+		if err != nil || v != expected {
+			t.Fatalf("expected=%v for %s, got=%v (%v)", expected, source, v, err)
+		}
+	}
+	for _, source := range []string{"bitCount(1.5);", "bitCount(-1);", "gcd(\"a\", 1);", "lcm(1, 2.5);"} {
+		if _, err := run(i, source); err == nil {
+			t.Fatalf("expected error for %s", source)
+		}
+	}
+	errors := map[string]string{
+		"bitCount(2e19);":          "Error: Argument 1 to 'bitCount' must be less than 2^64.\n",
+		"gcd(9.3e18, 3);":          "Error: Argument 1 to 'gcd' must be between -2^53 and 2^53.\n",
+		"lcm(3, -1e16);":           "Error: Argument 2 to 'lcm' must be between -2^53 and 2^53.\n",
+		"toExponential(1, 1e300);": "Error: Argument 2 to 'toExponential' must be between -2^53 and 2^53.\n",
+	}
+	for source, expected := range errors {
+		_, err := run(i, source)
+		if err == nil || err.Error() != expected {
+			t.Fatalf("expected=%q for %s, got=%v", expected, source, err)
+		}
+	}
+}
+
+func TestBigNumbers(t *testing.T) {
+	i := New()
+	v, err := run(i, "0.1 + 0.2 == 0.3;")
+	if err != nil || v != false {
+		t.Fatalf("expected=false with float64 numbers, got=%v (%v)", v, err)
+	}
+	i.BigNumbers = true
+	v, err = run(i, "0.1 + 0.2 == 0.3;")
+	if err != nil || v != true {
+		t.Fatalf("expected=true with big numbers, got=%v (%v)", v, err)
+	}
+	tests := map[string]string{
+		"0.1 + 0.2;":             "0.3",
+		"10 / 4;":                "2.5",
+		"1 / 3;":                 "0.3333333333333333",
+		"-(6 * 7);":              "-42",
+		"\"n=\" + 1.5;":          "n=1.5",
+		"toExponential(1.5, 1);": "1.5e+00",
+	}
+	for source, expected := range tests {
+		v, err := run(i, source)
+		if err != nil || i.stringify(v) != expected {
+			t.Fatalf("expected=%s for %s, got=%v (%v)", expected, source, i.stringify(v), err)
+		}
+	}
+	if _, err := run(i, "1 / 0;"); err == nil {
+		t.Fatalf("expected error dividing by zero")
+	}
+
+	// sort accepts rationals, even mixed with the float64s natives return.
+	sorted := map[string]string{
+		"sort([3, 1, 2]);":                                                     "[1, 2, 3]",
+		"sort([0.3, 0.1 + 0.1, 1 / 3]);":                                       "[0.2, 0.3, 0.3333333333333333]",
+		"var xs = range(3);\nxs[0] = 2.5;\nsort(xs);":                          "[1, 2, 2.5]",
+		"fun descending(a, b) { return b - a; }\nsort([3, 1, 2], descending);": "[3, 2, 1]",
+	}
+	for source, expected := range sorted {
+		v, err := run(i, source)
+		if err != nil || i.stringify(v) != expected {
+			t.Fatalf("expected=%s for %s, got=%v (%v)", expected, source, i.stringify(v), err)
+		}
+	}
+
+	// Infinities and NaN have no exact value, so arithmetic on them is an
+	// error rather than a crash.
+	errors := map[string]string{
+		"pow(10, 400) + 1;":           "[line 1] RuntimeError at '+': Cannot represent +Inf exactly.\n",
+		"1 - -pow(10, 400);":          "[line 1] RuntimeError at '-': Cannot represent -Inf exactly.\n",
+		"sqrt(4) < pow(10, 400) * 0;": "[line 1] RuntimeError at '*': Cannot represent +Inf exactly.\n",
+	}
+	for source, expected := range errors {
+		_, err := run(i, source)
+		if err == nil || err.Error() != expected {
+			t.Fatalf("expected=%q for %s, got=%v", expected, source, err)
+		}
+	}
+	v, err = run(i, "pow(10, 400) == 1;")
+	if err != nil || v != false {
+		t.Fatalf("expected an infinity not to equal a rational, got=%v (%v)", v, err)
+	}
+}
+
+func TestEquals(t *testing.T) {
+	i := New()
+	tests := map[string]bool{
+		"equals(range(3), range(3));":           true,
+		"range(3) == range(3);":                 false,
+		"equals(range(3), range(4));":           false,
+		"equals(chars(\"ab\"), chars(\"ab\"));": true,
+		"equals(sort(range(3)), range(3));":     true,
+		"equals(1, 1);":                         true,
+		"equals(nil, range(0));":                false,
+		"equals(range(0), nil);":                false,
+		"equals(\"a\", \"b\");":                 false,
+	}
+	for source, expected := range tests {
+		v, err := run(i, source)
+		if err != nil || v != expected {
+			t.Fatalf("expected=%v for %s, got=%v (%v)", expected, source, v, err)
+		}
+	}
+
+	nested := func() *LoxArray { return array(1.0, array("a", array(nil, true))) }
+	if !deepEqual(nested(), nested(), map[[2]*LoxArray]bool{}) {
+		t.Fatalf("expected nested arrays to be equal")
+	}
+	if deepEqual(nested(), array(1.0, array("a", array(nil, false))), map[[2]*LoxArray]bool{}) {
+		t.Fatalf("expected nested arrays to differ")
+	}
+
+	// Arrays that contain themselves must not recurse forever
+	a, b := array(1.0), array(1.0)
+	a.Elements = append(a.Elements, a)
+	b.Elements = append(b.Elements, b)
+	if !deepEqual(a, b, map[[2]*LoxArray]bool{}) {
+		t.Fatalf("expected cyclic arrays to be equal")
+	}
+}
+
+func TestTypeAnnotations(t *testing.T) {
+	i := New()
+	v, err := run(i, "fun add(a: number, b: number): number { print a + b; }\nvar x: string = \"hi\";\nx = \"there\";\nvar f: function = add;\nadd(1, 2);\nx;")
+	if err != nil || v != "there" {
+		t.Fatalf("expected=there, got=%v (%v)", v, err)
+	}
+	v, err = run(i, "var n: number;\nn = 2;\nn;")
+	if err != nil || v != 2.0 {
+		t.Fatalf("expected=2, got=%v (%v)", v, err)
+	}
+	errors := map[string]string{
+		"add(1, \"two\");":                 "[line 1] RuntimeError at 'b': Expected argument 'b' to be number, got string.\n",
+		"var y: boolean = 1;":              "[line 1] RuntimeError at 'y': Expected variable 'y' to be boolean, got number.\n",
+		"x = 3;":                           "[line 1] RuntimeError at 'x': Expected variable 'x' to be string, got number.\n",
+		"{ var z: number = 1; z = true; }": "[line 1] RuntimeError at 'z': Expected variable 'z' to be number, got boolean.\n",
+	}
+	for source, expected := range errors {
+		_, err := run(i, source)
+		if err == nil || !strings.HasPrefix(err.Error(), expected) {
+			t.Fatalf("expected=%q for %s, got=%v", expected, source, err)
+		}
+	}
+	// Unannotated variables still accept any value
+	if _, err := run(i, "var u = 1;\nu = \"one\";"); err != nil {
+		t.Fatalf("expected no error, got=%v", err)
+	}
+	// Redeclaring a variable without a type drops the old annotation
+	v, err = run(i, "var r: number = 1;\nvar r = \"s\";\nr = \"t\";\nr;")
+	if err != nil || v != "t" {
+		t.Fatalf("expected=t, got=%v (%v)", v, err)
+	}
+	v, err = run(i, "var w: number = 1;\nvar w;\nw = true;\nw;")
+	if err != nil || v != true {
+		t.Fatalf("expected=true, got=%v (%v)", v, err)
+	}
+}
+
+func TestInputTimeout(t *testing.T) {
+	reader, writer := io.Pipe()
+	var out bytes.Buffer
+	i := New()
+	i.In = reader
+	i.Out = &out
+
+	go writer.Write([]byte("first\n"))
+	v, err := run(i, "inputTimeout(\"> \", 1);")
+	if err != nil || v != "first" {
+		t.Fatalf("expected=first, got=%v (%v)", v, err)
+	}
+	if out.String() != "> " {
+		t.Fatalf("expected the prompt to be printed, got=%q", out.String())
+	}
+
+	// Nothing has been written, so the read times out
+	v, err = run(i, "inputTimeout(\"> \", 0.05);")
+	if err != nil || v != nil {
+		t.Fatalf("expected=nil, got=%v (%v)", v, err)
+	}
+
+	// A line arriving after the timeout is picked up by the next read
+	go writer.Write([]byte("second\r\n"))
+	v, err = run(i, "inputTimeout(\"> \", 1);")
+	if err != nil || v != "second" {
+		t.Fatalf("expected=second, got=%v (%v)", v, err)
+	}
+
+	writer.Close()
+	v, err = run(i, "inputTimeout(\"> \", 1);")
+	if err != nil || v != nil {
+		t.Fatalf("expected=nil at end of input, got=%v (%v)", v, err)
+	}
+	if _, err := run(i, "inputTimeout(\"> \", -1);"); err == nil {
+		t.Fatalf("expected an error for a negative timeout")
+	}
+	_, err = run(i, "inputTimeout(\"> \", 1e300);")
+	if err == nil || err.Error() != "Error: Timeout for 'inputTimeout' must be at most 9223372036 seconds.\n" {
+		t.Fatalf("expected an error for a timeout too long to represent, got=%v", err)
+	}
+}
+
+func TestEnum(t *testing.T) {
+	i := New()
+	if _, err := run(i, "enum Color { RED, GREEN, BLUE }\nenum Light { RED, OFF, }"); err != nil {
+		t.Fatalf("expected no error, got=%v", err)
+	}
+	tests := map[string]any{
+		"Color.RED == Color.RED;":   true,
+		"Color.RED == Color.GREEN;": false,
+		"Color.RED == Light.RED;":   false,
+		"Color.RED == 0;":           false,
+		"Color.BLUE != nil;":        true,
+	}
+	for source, expected := range tests {
+		v, err := run(i, source)
+		if err != nil || v != expected {
+			t.Fatalf("expected=%v for %s, got=%v (%v)", expected, source, v, err)
+		}
+	}
+	v, err := run(i, "var c = Color.GREEN;\nc;")
+	if err != nil || i.stringify(v) != "Color.GREEN" {
+		t.Fatalf("expected=Color.GREEN, got=%v (%v)", v, err)
+	}
+	v, _ = run(i, "Color;")
+	if i.stringify(v) != "<enum Color>" {
+		t.Fatalf("expected=<enum Color>, got=%v", i.stringify(v))
+	}
+	_, err = run(i, "Color.PURPLE;")
+	if err == nil || !strings.HasPrefix(err.Error(), "[line 1] RuntimeError at 'PURPLE': Undefined member 'PURPLE' of enum 'Color'.\n") {
+		t.Fatalf("expected undefined member error, got=%v", err)
+	}
+}
+
+func TestProperties(t *testing.T) {
+	i := New()
+	if _, err := run(i, "class Point { describe() { return \"a point\"; } }\nvar p = Point();\np.x = 1;\np.y = p.x + 1;"); err != nil {
+		t.Fatalf("expected no error, got=%v", err)
+	}
+	tests := map[string]any{
+		"p.x;":          1.0,
+		"p.y;":          2.0,
+		"p.x + p.y;":    3.0,
+		"p.describe();": "a point",
+		"p.inner = Point(); p.inner.z = 4; p.inner.z;": 4.0,
+	}
+	for source, expected := range tests {
+		v, err := run(i, source)
+		if err != nil || v != expected {
+			t.Fatalf("expected=%v for %s, got=%v (%v)", expected, source, v, err)
+		}
+	}
+	v, err := run(i, "p.x = p.y = 3;\np.x + p.y;")
+	if err != nil || v != 6.0 {
+		t.Fatalf("expected=6, got=%v (%v)", v, err)
+	}
+	v, _ = run(i, "p;")
+	if i.stringify(v) != "<Point instance>" {
+		t.Fatalf("expected=<Point instance>, got=%v", i.stringify(v))
+	}
+	_, err = run(i, "p.missing;")
+	if err == nil || !strings.HasPrefix(err.Error(), "[line 1] RuntimeError at 'missing': Undefined property 'missing'.\n") {
+		t.Fatalf("expected undefined property error, got=%v", err)
+	}
+	_, err = run(i, "var n = 1;\nn.x = 2;")
+	if err == nil || !strings.HasPrefix(err.Error(), "[line 2] RuntimeError at 'x': Only instances have fields.\n") {
+		t.Fatalf("expected non-instance error, got=%v", err)
+	}
+}
+
+func TestPrivateMembers(t *testing.T) {
+	i := New()
+	source := `class Account {
+  open(account, balance) { account._balance = balance; }
+  balance(account) { return account._balance; }
+  nested(account) { fun read() { return account._balance; } return read(); }
+  _audit() { return "audited"; }
+  audit(account) { return account._audit(); }
+}
+class Thief { steal(account) { return account._balance; } }
+var a = Account();
+a.open(a, 10);`
+	if _, err := run(i, source); err != nil {
+		t.Fatalf("expected no error, got=%v", err)
+	}
+	tests := map[string]any{
+		"a.balance(a);": 10.0,
+		"a.nested(a);":  10.0,
+		"a.audit(a);":   "audited",
+	}
+	for source, expected := range tests {
+		v, err := run(i, source)
+		if err != nil || v != expected {
+			t.Fatalf("expected=%v for %s, got=%v (%v)", expected, source, v, err)
+		}
+	}
+	errors := []string{"a._balance;", "a._balance = 0;", "a._audit();", "Thief().steal(a);"}
+	for _, source := range errors {
+		_, err := run(i, source)
+		if err == nil || !strings.Contains(err.Error(), "Cannot access private member '_") {
+			t.Fatalf("expected private member error for %s, got=%v", source, err)
+		}
+	}
+}
+
+func TestThis(t *testing.T) {
+	i := New()
+	source := `class Person {
+  greet() { return "Hello, " + this.name; }
+  rename(name) { this.name = name; return this; }
+  _secret() { return "private"; }
+  reveal() { return this._secret(); }
+}
+var p = Person();
+p.name = "Ada";`
+	if _, err := run(i, source); err != nil {
+		t.Fatalf("expected no error, got=%v", err)
+	}
+	// Each case may rename p, so they run in order.
+	tests := []struct {
+		source   string
+		expected any
+	}{
+		{"p.greet();", "Hello, Ada"},
+		{"p.rename(\"Grace\").greet();", "Hello, Grace"},
+		{"var greet = p.greet; p.name = \"Bo\"; greet();", "Hello, Bo"},
+		{"p.reveal();", "private"},
+	}
+	for _, test := range tests {
+		v, err := run(i, test.source)
+		if err != nil || v != test.expected {
+			t.Fatalf("expected=%v for %s, got=%v (%v)", test.expected, test.source, v, err)
+		}
+	}
+	if _, ok := i.globals.Values["this"]; ok {
+		t.Fatalf("expected this not to leak into the global scope")
+	}
+}
+
+func TestSuper(t *testing.T) {
+	i := New()
+	source := `class Animal {
+  speak() { return this.name + " makes a sound"; }
+  describe() { return "an animal"; }
+  _kind() { return "animal"; }
+}
+class Dog < Animal {
+  speak() { return super.speak() + " and barks"; }
+  kind() { return this._kind(); }
+}
+class Puppy < Dog {
+  speak() { return super.speak() + " softly"; }
+}
+var d = Dog();
+d.name = "Rex";
+var p = Puppy();
+p.name = "Bit";`
+	if _, err := run(i, source); err != nil {
+		t.Fatalf("expected no error, got=%v", err)
+	}
+	tests := map[string]any{
+		"d.speak();":    "Rex makes a sound and barks",
+		"d.describe();": "an animal",
+		"d.kind();":     "animal",
+		"p.speak();":    "Bit makes a sound and barks softly",
+	}
+	for source, expected := range tests {
+		v, err := run(i, source)
+		if err != nil || v != expected {
+			t.Fatalf("expected=%v for %s, got=%v (%v)", expected, source, v, err)
+		}
+	}
+	_, err := run(i, "var NotAClass = 1;\nclass Cat < NotAClass {}")
+	if err == nil || !strings.HasPrefix(err.Error(), "[line 2] RuntimeError at 'NotAClass': Superclass must be a class.\n") {
+		t.Fatalf("expected superclass error, got=%v", err)
+	}
+}
+
+func TestInitializer(t *testing.T) {
+	var out bytes.Buffer
+	i := New()
+	i.Out = &out
+	source := `class Point {
+  init(x, y) { this.x = x; this.y = y; }
+  sum() { return this.x + this.y; }
+}
+class Labelled < Point {}
+class Early {
+  init() { print "init"; return; print "unreachable"; }
+}
+var e = Early();`
+	if _, err := run(i, source); err != nil {
+		t.Fatalf("expected no error, got=%v", err)
+	}
+	if out.String() != "init\n" {
+		t.Fatalf("expected init to run once, got=%q", out.String())
+	}
+	tests := map[string]any{
+		"Point(2, 3).sum();":    5.0,
+		"Labelled(4, 5).sum();": 9.0,
+		"str(Point(1, 1));":     "<Point instance>",
+		"str(e);":               "<Early instance>",
+	}
+	for source, expected := range tests {
+		v, err := run(i, source)
+		if err != nil || v != expected {
+			t.Fatalf("expected=%v for %s, got=%v (%v)", expected, source, v, err)
+		}
+	}
+	_, err := run(i, "Point(1);")
+	if err == nil || err.Error() != "Error: Expected 2 arguments but got 1.\n" {
+		t.Fatalf("expected an arity error, got=%v", err)
+	}
+}
+
+func TestSpread(t *testing.T) {
+	i := New()
+	if _, err := run(i, "fun add(a, b, c) { return a + b + c; }\nvar bounds = range(2, 4);"); err != nil {
+		t.Fatalf("expected no error, got=%v", err)
+	}
+	tests := map[string]string{
+		"range(...bounds);":            "[2]",
+		"range(1, ...range(5, 6));":    "[1, 2, 3, 4]",
+		"add(...bounds, 4);":           "9",
+		"add(1, ...bounds);":           "6",
+		"add(...range(1), ...bounds);": "5",
+	}
+	for source, expected := range tests {
+		v, err := run(i, source)
+		if err != nil || i.stringify(v) != expected {
+			t.Fatalf("expected=%v for %s, got=%v (%v)", expected, source, v, err)
+		}
+	}
+	_, err := run(i, "add(...bounds);")
+	if err == nil || !strings.HasPrefix(err.Error(), "Error: Expected 3 arguments but got 2.") {
+		t.Fatalf("expected arity error, got=%v", err)
+	}
+	_, err = run(i, "add(...1);")
+	if err == nil || !strings.HasPrefix(err.Error(), "[line 1] RuntimeError at '...': Can only spread arrays into arguments.\n") {
+		t.Fatalf("expected spread error, got=%v", err)
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	i := New()
+	i.globals.Define("shallow", array(1.0, array(2.0, 3.0), 4.0))
+	i.globals.Define("deep", array(1.0, array(2.0, array(3.0, array("four", array())), 5.0), nil))
+	tests := map[string]string{
+		"flatten(shallow);":     "[1, 2, 3, 4]",
+		"flatten(deep);":        "[1, 2, [3, [four, []]], 5, nil]",
+		"flattenDeep(shallow);": "[1, 2, 3, 4]",
+		"flattenDeep(deep);":    "[1, 2, 3, four, 5, nil]",
+		"flatten(range(0));":    "[]",
+	}
+	for source, expected := range tests {
+		v, err := run(i, source)
+		if err != nil || i.stringify(v) != expected {
+			t.Fatalf("expected=%v for %s, got=%v (%v)", expected, source, v, err)
+		}
+	}
+	// Repeated arrays are fine, but one that contains itself is an error.
+	shared := array(1.0)
+	cycle := array(shared, shared)
+	cycle.Elements = append(cycle.Elements, array(cycle))
+	i.globals.Define("shared", array(shared, shared))
+	i.globals.Define("cycle", cycle)
+	v, err := run(i, "flattenDeep(shared);")
+	if err != nil || i.stringify(v) != "[1, 1]" {
+		t.Fatalf("expected=[1, 1], got=%v (%v)", v, err)
+	}
+	_, err = run(i, "flattenDeep(cycle);")
+	if err == nil || err.Error() != "Error: Cannot flatten an array that contains itself.\n" {
+		t.Fatalf("expected cycle error, got=%v", err)
+	}
+}
+
+func TestBreakContinue(t *testing.T) {
+	tests := map[string]string{
+		"for (var i = 0; i < 10; i = i + 1) { if (i == 3) break; print i; }":                                          "0\n1\n2\n",
+		"for (var i = 0; i < 5; i = i + 1) { if (i == 1 or i == 3) continue; print i; }":                              "0\n2\n4\n",
+		"var i = 0; while (true) { i = i + 1; if (i > 2) break; print i; }":                                           "1\n2\n",
+		"var i = 0; while (i < 4) { i = i + 1; if (i == 2) continue; print i; }":                                      "1\n3\n4\n",
+		"for (var i = 0; i < 2; i = i + 1) { for (var j = 0; j < 5; j = j + 1) { if (j == 1) break; print i + j; } }": "0\n1\n",
+		"fun f() { for (;;) { return \"done\"; } } print f();":                                                        "done\n",
+	}
+	for source, expected := range tests {
+		var out bytes.Buffer
+		i := New()
+		i.Out = &out
+		if _, err := run(i, source); err != nil {
+			t.Fatalf("expected no error for %s, got=%v", source, err)
+		}
+		if out.String() != expected {
+			t.Fatalf("expected=%q for %s, got=%q", expected, source, out.String())
+		}
+	}
+}
+
+func TestUnless(t *testing.T) {
+	tests := map[string]string{
+		"unless (false) print 1;":                      "1\n",
+		"unless (true) print 1;":                       "",
+		"unless (1 > 2) { print \"a\"; print \"b\"; }": "a\nb\n",
+		"fun f(n) { return \"small\" unless n > 9; return \"big\"; } print f(1); print f(10);": "small\nbig\n",
+		"fun g(n) { return unless n; print n; } g(false); g(2);":                               "2\n",
+		// An else after an unbraced unless belongs to the enclosing if
+		"if (true) unless (false) print 1; else print 2;":               "1\n",
+		"if (false) unless (false) print 1; else print 2;":              "2\n",
+		"if (false) while (true) unless (false) print 1; else print 2;": "2\n",
+	}
+	for source, expected := range tests {
+		var out bytes.Buffer
+		i := New()
+		i.Out = &out
+		if _, err := run(i, source); err != nil {
+			t.Fatalf("expected no error for %s, got=%v", source, err)
+		}
+		if out.String() != expected {
+			t.Fatalf("expected=%q for %s, got=%q", expected, source, out.String())
+		}
+	}
+
+	for _, source := range []string{
+		"unless (false) print 1; else print 2;",
+		"if (true) { unless (false) print 1; else print 2; }",
+	} {
+		_, err := run(New(), source)
+		if err == nil || !strings.Contains(err.Error(), "ParserError at 'else': Cannot use 'else' with 'unless'.") {
+			t.Fatalf("expected an else error for %s, got=%v", source, err)
+		}
+	}
+}
+
+func TestCountAndIndexOf(t *testing.T) {
+	i := New()
+	i.globals.Define("words", array("a", "b", "a", 1.0, "a"))
+	tests := map[string]any{
+		"count(\"banana\", \"an\");":   2.0,
+		"count(\"aaaa\", \"aa\");":     2.0,
+		"count(\"banana\", \"x\");":    0.0,
+		"count(words, \"a\");":         3.0,
+		"count(words, 1);":             1.0,
+		"count(range(0), nil);":        0.0,
+		"indexOf(words, \"b\");":       1.0,
+		"indexOf(words, 1);":           3.0,
+		"indexOf(words, \"z\");":       -1.0,
+		"indexOf(\"banana\", \"na\");": 2.0,
+		"indexOf(\"banana\", \"x\");":  -1.0,
+	}
+	for source, expected := range tests {
+		v, err := run(i, source)
+		if err != nil || v != expected {
+			t.Fatalf("expected=%v for %s, got=%v (%v)", expected, source, v, err)
+		}
+	}
+	_, err := run(i, "count(1, 1);")
+	if err == nil || err.Error() != "Error: Argument 1 to 'count' must be a string or an array.\n" {
+		t.Fatalf("expected type error, got=%v", err)
+	}
+	_, err = run(i, "indexOf(\"abc\", 1);")
+	if err == nil || err.Error() != "Error: Argument 2 to 'indexOf' must be a string.\n" {
+		t.Fatalf("expected type error, got=%v", err)
+	}
+}
+
+func TestTernary(t *testing.T) {
+	i := New()
+	if _, err := run(i, "var calls = 0;\nfun touch(v) { calls = calls + 1; return v; }"); err != nil {
+		t.Fatalf("expected no error, got=%v", err)
+	}
+	tests := map[string]any{
+		"true ? 1 : 2;":                          1.0,
+		"false ? 1 : 2;":                         2.0,
+		"nil ? \"yes\" : \"no\";":                "no",
+		"1 > 2 ? \"a\" : 2 > 1 ? \"b\" : \"c\";": "b",
+		"false ? 1 : false ? 2 : 3;":             3.0,
+		"true ? false ? 1 : 2 : 3;":              2.0,
+		"(true ? 1 : 2) + 10;":                   11.0,
+	}
+	for source, expected := range tests {
+		v, err := run(i, source)
+		if err != nil || v != expected {
+			t.Fatalf("expected=%v for %s, got=%v (%v)", expected, source, v, err)
+		}
+	}
+	// Only the branch taken is evaluated.
+	v, err := run(i, "calls = 0;\ntrue ? touch(1) : touch(2);\ncalls;")
+	if err != nil || v != 1.0 {
+		t.Fatalf("expected=1 call, got=%v (%v)", v, err)
+	}
+	if s := parse(t, "a ? b : c ? d : e;")[0].String(); s != "(expression (?: a b (?: c d e)))" {
+		t.Fatalf("expected right associativity, got=%s", s)
+	}
+}
+
+func TestHash(t *testing.T) {
+	i := New()
+	tests := map[string]any{
+		"hash(\"hello\");":   1335831723.0,
+		"hash(\"\");":        2166136261.0,
+		"sha256(\"hello\");": "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+		"sha256(\"\");":      "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+	}
+	for source, expected := range tests {
+		v, err := run(i, source)
+		if err != nil || v != expected {
+			t.Fatalf("expected=%v for %s, got=%v (%v)", expected, source, v, err)
+		}
+	}
+	for _, source := range []string{"hash(1);", "sha256(nil);"} {
+		if _, err := run(i, source); err == nil {
+			t.Fatalf("expected error for %s", source)
+		}
+	}
+}
+
+func TestBase64(t *testing.T) {
+	i := New()
+	tests := map[string]any{
+		"base64Encode(\"hello, world\");":              "aGVsbG8sIHdvcmxk",
+		"base64Encode(\"\");":                          "",
+		"base64Decode(\"aGVsbG8sIHdvcmxk\");":          "hello, world",
+		"base64Decode(base64Encode(\"round trip?\"));": "round trip?",
+		"base64Decode(\"not base64!\");":               nil,
+		"base64Decode(\"aGVsbG8\");":                   nil,
+	}
+	for source, expected := range tests {
+		v, err := run(i, source)
+		if err != nil || v != expected {
+			t.Fatalf("expected=%v for %s, got=%v (%v)", expected, source, v, err)
+		}
+	}
+	for _, source := range []string{"base64Encode(1);", "base64Decode(true);"} {
+		if _, err := run(i, source); err == nil {
+			t.Fatalf("expected error for %s", source)
+		}
+	}
+}
+
+func TestEachLine(t *testing.T) {
+	var out bytes.Buffer
+	i := New()
+	i.In = strings.NewReader("one\ntwo\r\nthree")
+	i.Out = &out
+	if _, err := run(i, "var count = 0;\nfun show(line) { count = count + 1; print line; }\neachLine(show);"); err != nil {
+		t.Fatalf("expected no error, got=%v", err)
+	}
+	if out.String() != "one\ntwo\nthree\n" {
+		t.Fatalf("expected=%q, got=%q", "one\ntwo\nthree\n", out.String())
+	}
+	if v := lookup(t, i, "count"); v != 3.0 {
+		t.Fatalf("expected=3 calls, got=%v", v)
+	}
+
+	// Returning false stops reading, leaving the rest of the input unread
+	var seen []any
+	i = New()
+	i.In = strings.NewReader("a\nstop\nb\n")
+	i.globals.Define("until", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			seen = append(seen, arguments[0])
+			return arguments[0] != "stop", nil
+		},
+		arity: 1,
+	})
+	if _, err := run(i, "eachLine(until);"); err != nil {
+		t.Fatalf("expected no error, got=%v", err)
+	}
+	if len(seen) != 2 || seen[1] != "stop" {
+		t.Fatalf("expected reading to stop after 'stop', got=%v", seen)
+	}
+	if v, _ := run(i, "inputTimeout(\"\", 1);"); v != "b" {
+		t.Fatalf("expected the remaining line to be unread, got=%v", v)
+	}
+
+	// Errors from the callback propagate
+	i = New()
+	i.In = strings.NewReader("x\n")
+	if _, err := run(i, "fun fail(line) { 1 / 0; }\neachLine(fail);"); err == nil {
+		t.Fatalf("expected the callback's error to propagate")
+	}
+}
+
+func TestQueue(t *testing.T) {
+	i := New()
+	v, err := run(i, "var q = range(0);\nenqueue(q, 1);\nenqueue(q, \"two\");\nenqueue(q, 3);\npeek(q);")
+	if err != nil || v != 1.0 {
+		t.Fatalf("expected=1, got=%v (%v)", v, err)
+	}
+	for _, expected := range []any{1.0, "two", 3.0, nil, nil} {
+		v, err := run(i, "dequeue(q);")
+		if err != nil || v != expected {
+			t.Fatalf("expected=%v, got=%v (%v)", expected, v, err)
+		}
+	}
+	v, err = run(i, "peek(q);")
+	if err != nil || v != nil {
+		t.Fatalf("expected=nil from an empty queue, got=%v (%v)", v, err)
+	}
+	for _, source := range []string{"enqueue(1, 2);", "dequeue(\"abc\");", "peek(nil);"} {
+		if _, err := run(i, source); err == nil {
+			t.Fatalf("expected error for %s", source)
+		}
+	}
+}
+
+func TestTimeFormatting(t *testing.T) {
+	i := New()
+	tests := map[string]any{
+		"formatDuration(3723);":                             "1h2m3s",
+		"formatDuration(1.5);":                              "1.5s",
+		"formatDuration(0);":                                "0s",
+		"formatDuration(-90);":                              "-1m30s",
+		"parseTime(\"2024-02-29\", \"date\");":              1709164800.0,
+		"parseTime(\"1970-01-01 00:01:40\", \"datetime\");": 100.0,
+		"parseTime(\"yesterday\", \"date\");":               nil,
+		"formatTime(1709164800, \"date\");":                 "2024-02-29",
+		"formatTime(parseTime(\"2024-02-29T12:30:00Z\", \"rfc3339\"), \"rfc3339\");": "2024-02-29T12:30:00Z",
+	}
+	for source, expected := range tests {
+		v, err := run(i, source)
+		if err != nil || v != expected {
+			t.Fatalf("expected=%v for %s, got=%v (%v)", expected, source, v, err)
+		}
+	}
+	for _, source := range []string{"formatDuration(\"1h\");", "parseTime(1, \"date\");", "parseTime(\"2024-02-29\", \"iso\");", "formatTime(1.5, \"date\");"} {
+		if _, err := run(i, source); err == nil {
+			t.Fatalf("expected error for %s", source)
+		}
+	}
+	for _, source := range []string{"formatDuration(1e300);", "formatDuration(-1e300);"} {
+		_, err := run(i, source)
+		if err == nil || err.Error() != "Error: Argument 1 to 'formatDuration' must be between -9223372036 and 9223372036.\n" {
+			t.Fatalf("expected a range error for %s, got=%v", source, err)
+		}
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	i := New()
+	plus := token.Token{Type: token.PLUS, Lexeme: "+", Line: 1}
+	star := token.Token{Type: token.STAR, Lexeme: "*", Line: 1}
+	// (1 + 2) * 4
+	expr := &ast.Binary{
+		Left:     &ast.Grouping{Expression: &ast.Binary{Left: &ast.Literal{Value: 1.0}, Operator: plus, Right: &ast.Literal{Value: 2.0}}},
+		Operator: star,
+		Right:    &ast.Literal{Value: 4.0},
+	}
+	v, err := i.Evaluate(expr)
+	if err != nil || v != 12.0 {
+		t.Fatalf("expected=12, got=%v (%v)", v, err)
+	}
+	// Nodes see the interpreter's current environment
+	run(i, "var x = 5;")
+	v, err = i.Evaluate(&ast.Binary{Left: &ast.Variable{Name: token.Token{Type: token.IDENTIFIER, Lexeme: "x", Line: 1}}, Operator: plus, Right: &ast.Literal{Value: 1.0}})
+	if err != nil || v != 6.0 {
+		t.Fatalf("expected=6, got=%v (%v)", v, err)
+	}
+	if _, err := i.Evaluate(&ast.Binary{Left: &ast.Literal{Value: "a"}, Operator: star, Right: &ast.Literal{Value: 1.0}}); err == nil {
+		t.Fatalf("expected an error multiplying a string")
+	}
+	// Only the parser stops a defer at the top level, so a built one errors
+	deferKeyword := token.Token{Type: token.DEFER, Lexeme: "defer", Line: 1}
+	_, err = i.Evaluate(&ast.Defer{Keyword: deferKeyword, Expression: &ast.Literal{Value: 1.0}})
+	if err == nil || err.Error() != "[line 1] RuntimeError at 'defer': Cannot use 'defer' outside of a function.\n" {
+		t.Fatalf("expected a defer error, got=%v", err)
+	}
+}
+
+func TestRepr(t *testing.T) {
+	var out bytes.Buffer
+	i := New()
+	i.Out = &out
+	if _, err := run(i, "print \"x\";\nprint repr(\"x\");"); err != nil {
+		t.Fatalf("expected no error, got=%v", err)
+	}
+	if out.String() != "x\n\"x\"\n" {
+		t.Fatalf("expected=%q, got=%q", "x\n\"x\"\n", out.String())
+	}
+	run(i, "fun add(a, b) {}\nenum Color { RED }")
+	tests := map[string]string{
+		"repr(1.5);":                 "1.5",
+		"repr(nil);":                 "nil",
+		"repr(true);":                "true",
+		"repr(chars(\"ab\"));":       "[\"a\", \"b\"]",
+		"repr(sort(chars(\"ba\")));": "[\"a\", \"b\"]",
+		"repr(add);":                 "<fn add>",
+		"repr(clock);":               "<native fn>",
+		"repr(Color.RED);":           "Color.RED",
+	}
+	for source, expected := range tests {
+		v, err := run(i, source)
+		if err != nil || v != expected {
+			t.Fatalf("expected=%s for %s, got=%v (%v)", expected, source, v, err)
+		}
+	}
+	nested := array(1.0, array("a", array(nil)), "b")
+	if i.repr(nested) != "[1, [\"a\", [nil]], \"b\"]" {
+		t.Fatalf("expected=%s, got=%s", "[1, [\"a\", [nil]], \"b\"]", i.repr(nested))
+	}
+}
+
+func TestFormatCycles(t *testing.T) {
+	var out bytes.Buffer
+	i := New()
+	i.Out = &out
+	done := make(chan error)
+	go func() {
+		_, err := run(i, "var a = chars(\"x\");\nenqueue(a, a);\nprint a;\nprint repr(a);\nvar b = chars(\"y\");\nenqueue(b, a);\nenqueue(a, b);\nprint b;")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected no error, got=%v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("printing a self-referential array did not terminate")
+	}
+	expected := "[x, [...]]\n[\"x\", [...]]\n[y, [x, [...], [...]]]\n"
+	if out.String() != expected {
+		t.Fatalf("expected=%q, got=%q", expected, out.String())
+	}
+
+	// The same array appearing twice without a cycle is printed in full
+	shared := array(1.0)
+	if i.stringify(array(shared, shared)) != "[[1], [1]]" {
+		t.Fatalf("expected=[[1], [1]], got=%s", i.stringify(array(shared, shared)))
+	}
+
+	i.MaxFormatDepth = 2
+	if i.stringify(array(array(array(1.0)))) != "[[[...]]]" {
+		t.Fatalf("expected=[[[...]]], got=%s", i.stringify(array(array(array(1.0)))))
+	}
+	// The limit belongs to each interpreter
+	if other := New(); other.stringify(array(array(array(1.0)))) != "[[[1]]]" {
+		t.Fatalf("expected=[[[1]]], got=%s", other.stringify(array(array(array(1.0)))))
+	}
+}
+
+func TestClampAndLerp(t *testing.T) {
+	i := New()
+	tests := map[string]float64{
+		"clamp(5, 0, 10);":   5,
+		"clamp(-3, 0, 10);":  0,
+		"clamp(12, 0, 10);":  10,
+		"clamp(4, 4, 4);":    4,
+		"lerp(2, 10, 0);":    2,
+		"lerp(2, 10, 0.5);":  6,
+		"lerp(2, 10, 1);":    10,
+		"lerp(2, 10, 1.5);":  14,
+		"lerp(2, 10, -0.5);": -2,
+	}
+	for source, expected := range tests {
+		v, err := run(i, source)
+		if err != nil || v != expected {
+			t.Fatalf("expected=%v for %s, got=%v (%v)", expected, source, v, err)
+		}
+	}
+	for _, source := range []string{"clamp(1, 10, 0);", "clamp(\"1\", 0, 10);", "lerp(0, nil, 1);"} {
+		if _, err := run(i, source); err == nil {
+			t.Fatalf("expected error for %s", source)
+		}
+	}
+}
+
+func TestPrintFlushes(t *testing.T) {
+	var out, errOut bytes.Buffer
+	i := New()
+	i.Out = bufio.NewWriter(&out)
+	i.Err = bufio.NewWriter(&errOut)
+	i.In = strings.NewReader("")
+	run(i, "print \"hello\";")
+	if out.String() != "hello\n" {
+		t.Fatalf("expected print to flush, got=%q", out.String())
+	}
+	run(i, "inputTimeout(\"> \", 1);")
+	if out.String() != "hello\n> " {
+		t.Fatalf("expected the prompt to be flushed, got=%q", out.String())
+	}
+	run(i, "eprint(\"oops\");")
+	if errOut.String() != "oops\n" {
+		t.Fatalf("expected eprint to flush, got=%q", errOut.String())
+	}
+}
+
+func TestConstants(t *testing.T) {
+	i := New()
+	v, err := run(i, "const limit = 10;\nlimit * 2;")
+	if err != nil || v != 20.0 {
+		t.Fatalf("expected=20, got=%v (%v)", v, err)
+	}
+	_, err = run(i, "limit = 11;")
+	if err == nil || !strings.HasPrefix(err.Error(), "[line 1] RuntimeError at 'limit': Cannot assign to constant 'limit'.\n") {
+		t.Fatalf("expected an error assigning to a constant, got=%v", err)
+	}
+
+	// The initializer of a lazy constant runs once, on first read
+	run(i, "var calls = 0;\nlazy const config = calls = calls + 1;")
+	if lookup(t, i, "calls") != 0.0 {
+		t.Fatalf("expected the initializer not to run yet, got calls=%v", lookup(t, i, "calls"))
+	}
+	for n := 0; n < 3; n++ {
+		v, err := run(i, "config;")
+		if err != nil || v != 1.0 {
+			t.Fatalf("expected=1, got=%v (%v)", v, err)
+		}
+	}
+	if lookup(t, i, "calls") != 1.0 {
+		t.Fatalf("expected the initializer to run once, got calls=%v", lookup(t, i, "calls"))
+	}
+	if _, err := run(i, "config = 2;"); err == nil {
+		t.Fatalf("expected an error assigning to a lazy constant")
+	}
+
+	// The initializer sees the scope it was declared in
+	v, err = run(i, "var outer = 1;\n{ var inner = 41; lazy const sum = outer + inner; outer = sum; }\nouter;")
+	if err != nil || v != 42.0 {
+		t.Fatalf("expected=42, got=%v (%v)", v, err)
+	}
+	_, err = run(i, "lazy const loop = loop + 1;\nloop;")
+	if err == nil || !strings.Contains(err.Error(), "Lazy constant 'loop' depends on itself.") {
+		t.Fatalf("expected a self-reference error, got=%v", err)
+	}
+
+	// Redeclaring a constant with var makes it assignable again
+	v, err = run(New(), "const c = 1;\nvar c = 2;\nc = 3;\nc;")
+	if err != nil || v != 3.0 {
+		t.Fatalf("expected=3, got=%v (%v)", v, err)
+	}
+	v, err = run(New(), "const d = 1;\nvar d;\nd = 4;\nd;")
+	if err != nil || v != 4.0 {
+		t.Fatalf("expected=4, got=%v (%v)", v, err)
+	}
+}
+
+func TestReturn(t *testing.T) {
+	i := New()
+	tests := map[string]any{
+		"fun add(a, b) { return a + b; }\nadd(2, 3);":                                          5.0,
+		"fun nothing() { return; }\nnothing();":                                                nil,
+		"fun implicit() { var a = 1; }\nimplicit();":                                           nil,
+		"fun find() { var n = 0; while (true) { n = n + 1; if (n == 3) return n; } }\nfind();": 3.0,
+		"fun sign(n) { if (n < 0) { return \"negative\"; } return \"positive\"; }\nsign(-1);":  "negative",
+		"fun early() { return 1; print \"unreachable\"; }\nearly();":                           1.0,
+		"fun fib(n) { if (n < 2) return n; return fib(n - 1) + fib(n - 2); }\nfib(10);":        55.0,
+		"fun sum(a, b) { return a + b; }\nreduce(range(4), sum, 0);":                           6.0,
+	}
+	for source, expected := range tests {
+		v, err := run(i, source)
+		if err != nil || v != expected {
+			t.Fatalf("expected=%v for %s, got=%v (%v)", expected, source, v, err)
+		}
+	}
+
+	// Returning from inside a block leaves the caller in its own scope
+	v, err := run(i, "var x = \"global\";\nfun f() { var x = \"local\"; { return 1; } }\nf();\nx;")
+	if err != nil || v != "global" {
+		t.Fatalf("expected=global, got=%v (%v)", v, err)
+	}
+
+	// Deferred expressions still run when a function returns early
+	var out bytes.Buffer
+	i.Out = &out
+	v, err = run(i, "fun cleanup() { print \"cleanup\"; }\nfun g() { defer cleanup(); return \"done\"; }\ng();")
+	if err != nil || v != "done" || out.String() != "cleanup\n" {
+		t.Fatalf("expected=done after cleanup, got=%v %q (%v)", v, out.String(), err)
+	}
+
+	// Annotated return types are checked against the returned value
+	_, err = run(i, "fun bad(): number { return \"one\"; }\nbad();")
+	if err == nil || !strings.HasPrefix(err.Error(), "[line 1] RuntimeError at 'bad': Expected return value of 'bad' to be number, got string.\n") {
+		t.Fatalf("expected a return type error, got=%v", err)
+	}
+}
+
+func TestDeclareAssign(t *testing.T) {
+	var out bytes.Buffer
+	i := New()
+	i.Out = &out
+	i.In = strings.NewReader("one\ntwo\nthree\n")
+	_, err := run(i, "while ((line := inputTimeout(\"\", 1)) != nil) {\n  print line;\n}")
+	if err != nil {
+		t.Fatalf("expected no error, got=%v", err)
+	}
+	if out.String() != "one\ntwo\nthree\n" {
+		t.Fatalf("expected=%q, got=%q", "one\ntwo\nthree\n", out.String())
+	}
+
+	// The variable is declared in the scope enclosing the condition, and
+	// shadows rather than overwrites an outer variable
+	tests := map[string]any{
+		"if (n := 5) { n = n * 2; }\nn;":                               10.0,
+		"var x = 1;\n{ if (x := 2) {} }\nx;":                           1.0,
+		"fun f() { if (r := 3) return r; }\nf();":                      3.0,
+		"var y = 1;\ny := y + 1;":                                      2.0,
+		"_ := 7;":                                                      7.0,
+		"fun typed(a: number) { a := \"text\"; return a; }\ntyped(1);": "text",
+	}
+	for source, expected := range tests {
+		v, err := run(i, source)
+		if err != nil || v != expected {
+			t.Fatalf("expected=%v for %s, got=%v (%v)", expected, source, v, err)
+		}
+	}
+	if _, err := run(i, "const c = 1;\nc := 2;"); err == nil {
+		t.Fatalf("expected an error redeclaring a constant")
+	}
+}
+
+func TestClosures(t *testing.T) {
+	i := New()
+	source := `fun makeCounter() {
+  var count = 0;
+  fun increment() {
+    count = count + 1;
+    return count;
+  }
+  return increment;
+}
+var a = makeCounter();
+var b = makeCounter();
+a();
+a();
+b();
+var results = chars("");
+enqueue(results, a());
+enqueue(results, b());
+results;`
+	v, err := run(i, source)
+	if err != nil || i.stringify(v) != "[3, 2]" {
+		t.Fatalf("expected=[3, 2], got=%v (%v)", i.stringify(v), err)
+	}
+
+	// Functions see the variables where they were declared, not where
+	// they are called
+	v, err = run(i, "var name = \"global\";\nfun show() { return name; }\nfun caller() { var name = \"local\"; return show(); }\ncaller();")
+	if err != nil || v != "global" {
+		t.Fatalf("expected=global, got=%v (%v)", v, err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	i := New()
+	globals := len(i.globals.Values)
+	v, err := run(i, "var a = range(3);\nvar b = chars(\"ab\");\nsort(b);\nstats();")
+	if err != nil {
+		t.Fatalf("expected no error, got=%v", err)
+	}
+	expected := fmt.Sprintf("[[globals, %d], [depth, 0], [arrays, 3]]", globals+2)
+	if i.stringify(v) != expected {
+		t.Fatalf("expected=%s, got=%s", expected, i.stringify(v))
+	}
+	v, _ = run(i, "fun nested() { { return stats(); } }\nnested();")
+	depth := v.(*LoxArray).Elements[1].(*LoxArray).Elements[1]
+	if depth != 2.0 {
+		t.Fatalf("expected=2, got=%v", depth)
+	}
+}
+
+func TestSqrt(t *testing.T) {
+	i := New()
+	tests := map[string]float64{
+		"sqrt(16);":   4,
+		"sqrt(2.25);": 1.5,
+		"sqrt(0);":    0,
+	}
+	for source, expected := range tests {
+		v, err := run(i, source)
+		if err != nil || v != expected {
+			t.Fatalf("expected=%v for %s, got=%v (%v)", expected, source, v, err)
+		}
+	}
+	for _, source := range []string{"sqrt(\"foo\");", "sqrt(-1);", "sqrt(nil);"} {
+		if _, err := run(i, source); err == nil {
+			t.Fatalf("expected error for %s", source)
+		}
+	}
+}
+
+func TestMathNatives(t *testing.T) {
+	i := New()
+	tests := map[string]float64{
+		"floor(2.7);":    2,
+		"floor(-2.2);":   -3,
+		"ceil(2.2);":     3,
+		"ceil(-2.7);":    -2,
+		"round(2.5);":    3,
+		"round(-2.5);":   -3,
+		"round(2.4);":    2,
+		"round(-2.4);":   -2,
+		"abs(-3.5);":     3.5,
+		"abs(4);":        4,
+		"min(2, -3);":    -3,
+		"min(-1.5, -1);": -1.5,
+		"max(2, -3);":    2,
+		"max(-1.5, -1);": -1,
+	}
+	for source, expected := range tests {
+		v, err := run(i, source)
+		if err != nil || v != expected {
+			t.Fatalf("expected=%v for %s, got=%v (%v)", expected, source, v, err)
+		}
+	}
+	errors := map[string]string{
+		"floor(\"1\");":  "Error: Argument 1 to 'floor' must be a number.\n",
+		"ceil(nil);":     "Error: Argument 1 to 'ceil' must be a number.\n",
+		"round(true);":   "Error: Argument 1 to 'round' must be a number.\n",
+		"abs([1]);":      "Error: Argument 1 to 'abs' must be a number.\n",
+		"min(1, \"2\");": "Error: Argument 2 to 'min' must be a number.\n",
+		"max(nil, 2);":   "Error: Argument 1 to 'max' must be a number.\n",
+	}
+	for source, expected := range errors {
+		_, err := run(i, source)
+		if err == nil || err.Error() != expected {
+			t.Fatalf("expected=%q for %s, got=%v", expected, source, err)
+		}
+	}
+}
+
+func TestPowAndConstants(t *testing.T) {
+	var out bytes.Buffer
+	i := NewWithOutput(&out, io.Discard)
+	tests := map[string]any{
+		"pow(2, 10) == 1024;": true,
+		"pow(2, -1);":         0.5,
+		"pow(-2, 3);":         -8.0,
+		"pow(9, 0.5);":        3.0,
+		"E;":                  math.E,
+	}
+	for source, expected := range tests {
+		v, err := run(i, source)
+		if err != nil || v != expected {
+			t.Fatalf("expected=%v for %s, got=%v (%v)", expected, source, v, err)
+		}
+	}
+	// PI and E are ordinary globals, so scripts can reuse the names
+	if v, err := run(New(), "var E = 5;\nE = 6;\nE;"); err != nil || v != 6.0 {
+		t.Fatalf("expected=6, got=%v (%v)", v, err)
+	}
+	if _, err := run(i, "print PI;"); err != nil || out.String() != "3.141592653589793\n" {
+		t.Fatalf("expected=%q, got=%q (%v)", "3.141592653589793\n", out.String(), err)
+	}
+	errors := map[string]string{
+		"pow(\"2\", 1);": "Error: Argument 1 to 'pow' must be a number.\n",
+	}
+	for source, expected := range errors {
+		_, err := run(i, source)
+		if err == nil || err.Error() != expected {
+			t.Fatalf("expected=%q for %s, got=%v", expected, source, err)
+		}
+	}
+}
+
+func TestClock(t *testing.T) {
+	v, err := run(New(), "var start = clock();\nvar n = 0;\nwhile (n < 1000) n = n + 1;\nclock() - start;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	elapsed, ok := v.(float64)
+	if !ok || elapsed <= 0 || elapsed >= 1 {
+		t.Fatalf("expected a small positive number of seconds, got=%v", v)
+	}
+	// clock returns the same kind of number as a literal, so it works with
+	// every arithmetic operator.
+	for _, source := range []string{"clock() * 1000 > 0;", "-clock() < 0;", "clock() / 2 + 1 > 1;", "clock() >= 0;"} {
+		v, err := run(New(), source)
+		if err != nil || v != true {
+			t.Fatalf("expected %s to be true, got=%v (%v)", source, v, err)
+		}
+	}
+}
+
+func TestChainedAssignment(t *testing.T) {
+	var out bytes.Buffer
+	i := New()
+	i.Out = &out
+	v, err := run(i, "var a;\nvar b;\nvar c = a = b = 5;\nprint a;\nprint b;\nc;")
+	if err != nil || v != 5.0 {
+		t.Fatalf("expected=5, got=%v (%v)", v, err)
+	}
+	if out.String() != "5\n5\n" {
+		t.Fatalf("expected=%q, got=%q", "5\n5\n", out.String())
+	}
+	v, err = run(i, "_ = a = b = \"x\";\nb;")
+	if err != nil || v != "x" || lookup(t, i, "a") != "x" {
+		t.Fatalf("expected=x, got=%v (%v)", v, err)
+	}
+}
+
+func TestPrintFormatting(t *testing.T) {
+	var out bytes.Buffer
+	i := New()
+	i.Out = &out
+	run(i, "print 5/2;\nprint 10.0;\nprint true;\nprint false;\nprint nil;\nprint 100000000;\nprint -3;\nprint 0.1 + 0.2;\nprint 100000000000 * 100000000000;")
+	expected := "2.5\n10\ntrue\nfalse\nnil\n100000000\n-3\n0.30000000000000004\n1e+22\n"
+	if out.String() != expected {
+		t.Fatalf("expected=%q, got=%q", expected, out.String())
+	}
+}
+
+func TestTrigonometry(t *testing.T) {
+	i := New()
+	tests := map[string]float64{
+		"sin(radians(90));":           1,
+		"cos(0);":                     1,
+		"tan(radians(45));":           1,
+		"degrees(atan2(1, 1));":       45,
+		"degrees(3.141592653589793);": 180,
+		"radians(180);":               3.141592653589793,
+	}
+	for source, expected := range tests {
+		v, err := run(i, source)
+		if err != nil {
+			t.Fatalf("expected no error for %s, got=%v", source, err)
+		}
+		if math.Abs(v.(float64)-expected) > 1e-9 {
+			t.Fatalf("expected≈%v for %s, got=%v", expected, source, v)
+		}
+	}
+	for _, source := range []string{"sin(\"0\");", "atan2(1, nil);", "degrees(true);"} {
+		if _, err := run(i, source); err == nil {
+			t.Fatalf("expected error for %s", source)
+		}
+	}
+}
+
+func TestWith(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resource.lox")
+	resource := "var closed = 0;\nfun close() { closed = closed + 1; }\n"
+	if err := os.WriteFile(path, []byte(resource), 0644); err != nil {
+		t.Fatal(err)
+	}
+	i := New()
+	v, err := run(i, "import \""+path+"\" as resource;\nvar used = false;\nwith (var r = resource) { used = r.closed == 0; }\nresource.closed;")
+	if err != nil || v != 1.0 || lookup(t, i, "used") != true {
+		t.Fatalf("expected close to run once after the body, got=%v (%v)", v, err)
+	}
+
+	// close runs when the body fails, and the body's error is kept
+	_, err = run(i, "with (var r = resource) { 1 / 0; }")
+	if err == nil || !strings.Contains(err.Error(), "Division by zero.") {
+		t.Fatalf("expected the body's error, got=%v", err)
+	}
+	if v, _ := run(i, "resource.closed;"); v != 2.0 {
+		t.Fatalf("expected close to run after an error, got closed=%v", v)
+	}
+
+	// close runs when the body returns from a function
+	v, err = run(i, "fun use() { with (var r = resource) { return \"early\"; } }\nuse();")
+	if err != nil || v != "early" {
+		t.Fatalf("expected=early, got=%v (%v)", v, err)
+	}
+	if v, _ := run(i, "resource.closed;"); v != 3.0 {
+		t.Fatalf("expected close to run after a return, got closed=%v", v)
+	}
+
+	// The resource is only visible inside the statement
+	if _, err := run(i, "r;"); err == nil {
+		t.Fatalf("expected the resource name to be scoped to the with statement")
+	}
+	_, err = run(i, "with (var n = 1) {}")
+	if err == nil || !strings.HasPrefix(err.Error(), "[line 1] RuntimeError at 'with': Resource bound by 'with' has no 'close' method.\n") {
+		t.Fatalf("expected a missing close error, got=%v", err)
+	}
+}
+
+func TestRandomIdentifiers(t *testing.T) {
+	i := New()
+	format := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	seen := map[any]bool{}
+	for n := 0; n < 10; n++ {
+		v, err := run(i, "uuid();")
+		if err != nil || !format.MatchString(v.(string)) {
+			t.Fatalf("expected a v4 UUID, got=%v (%v)", v, err)
+		}
+		if seen[v] {
+			t.Fatalf("expected distinct UUIDs, got %v twice", v)
+		}
+		seen[v] = true
+	}
+	v, err := run(i, "randomBytes(4);")
+	if err != nil || !regexp.MustCompile(`^[0-9a-f]{8}$`).MatchString(v.(string)) {
+		t.Fatalf("expected 8 hex characters, got=%v (%v)", v, err)
+	}
+	if v, _ := run(i, "randomBytes(0);"); v != "" {
+		t.Fatalf("expected an empty string, got=%v", v)
+	}
+	for _, source := range []string{"randomBytes(-1);", "randomBytes(1.5);", "randomBytes(\"4\");"} {
+		if _, err := run(i, source); err == nil {
+			t.Fatalf("expected error for %s", source)
+		}
+	}
+	if v, err := run(i, "randomBytes(1048576);"); err != nil || len(v.(string)) != 2*1048576 {
+		t.Fatalf("expected the largest request to succeed, got=%v", err)
+	}
+	_, err = run(i, "randomBytes(1e12);")
+	if err == nil || err.Error() != "Error: Argument 1 to 'randomBytes' must be at most 1048576.\n" {
+		t.Fatalf("expected a size error, got=%v", err)
+	}
+}
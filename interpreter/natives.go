@@ -0,0 +1,1229 @@
+package interpreter
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/big"
+	"math/bits"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lowercasename/golox/environment"
+	"github.com/lowercasename/golox/logger"
+)
+
+// exit ends the process. Tests replace it so that calling the exit native
+// doesn't stop them.
+var exit = os.Exit
+
+// maxExactInteger is the largest whole number integerArgument accepts, 2^53,
+// above which not every integer can be represented as a number.
+const maxExactInteger = 1 << 53
+
+// maxRandomBytes is the most bytes randomBytes generates in one call.
+const maxRandomBytes = 1 << 20
+
+// defineNatives adds the built-in functions to the global environment.
+func defineNatives(globals *environment.Environment) {
+	// Return the time in seconds, with a fractional part for timing code.
+	globals.Define("clock", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			return float64(time.Now().UnixNano()) / 1e9, nil
+		},
+		arity: 0,
+	})
+	// Return the square root of a non-negative number.
+	globals.Define("sqrt", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			argument, err := numberArgument("sqrt", arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			if argument < 0 {
+				return nil, logger.InterpreterError("Cannot take the square root of a negative number.")
+			}
+			return math.Sqrt(argument), nil
+		},
+		arity: 1,
+	})
+	// Rounding, with round taking halves away from zero, and absolute value.
+	globals.Define("floor", mathFunction("floor", math.Floor))
+	globals.Define("ceil", mathFunction("ceil", math.Ceil))
+	globals.Define("round", mathFunction("round", math.Round))
+	globals.Define("abs", mathFunction("abs", math.Abs))
+	// Return the smaller of two numbers.
+	globals.Define("min", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			a, err := numberArgument("min", arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			b, err := numberArgument("min", arguments, 1)
+			if err != nil {
+				return nil, err
+			}
+			return math.Min(a, b), nil
+		},
+		arity: 2,
+	})
+	// Return the larger of two numbers.
+	globals.Define("max", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			a, err := numberArgument("max", arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			b, err := numberArgument("max", arguments, 1)
+			if err != nil {
+				return nil, err
+			}
+			return math.Max(a, b), nil
+		},
+		arity: 2,
+	})
+	// Raise a number to a power.
+	globals.Define("pow", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			base, err := numberArgument("pow", arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			exponent, err := numberArgument("pow", arguments, 1)
+			if err != nil {
+				return nil, err
+			}
+			return math.Pow(base, exponent), nil
+		},
+		arity: 2,
+	})
+	// Count the characters in a string or the elements of an array.
+	globals.Define("len", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			switch v := arguments[0].(type) {
+			case string:
+				return float64(utf8.RuneCountInString(v)), nil
+			case *LoxArray:
+				return float64(len(v.Elements)), nil
+			}
+			return nil, logger.InterpreterError("Argument to 'len' must be a string or an array.")
+		},
+		arity: 1,
+	})
+	// Parse a string as a number.
+	globals.Define("num", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			s, err := stringArgument("num", arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+			if err != nil || math.IsInf(n, 0) || math.IsNaN(n) {
+				return nil, logger.InterpreterError(fmt.Sprintf("Cannot convert %q to a number.", s))
+			}
+			if interpreter.BigNumbers {
+				r, _ := floatToRat(n)
+				return r, nil
+			}
+			return n, nil
+		},
+		arity: 1,
+	})
+	// Convert any value to the string print would show for it.
+	globals.Define("str", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			return interpreter.stringify(arguments[0]), nil
+		},
+		arity: 1,
+	})
+	// Split a string into an array of its characters.
+	globals.Define("chars", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			s, ok := arguments[0].(string)
+			if !ok {
+				return nil, logger.InterpreterError("Argument to 'chars' must be a string.")
+			}
+			elements := []any{}
+			for _, r := range s {
+				elements = append(elements, string(r))
+			}
+			return interpreter.newArray(elements), nil
+		},
+		arity: 1,
+	})
+	// Return the characters of s from start up to but not including end.
+	globals.Define("substr", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			s, err := stringArgument("substr", arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			start, err := integerArgument("substr", arguments, 1)
+			if err != nil {
+				return nil, err
+			}
+			end, err := integerArgument("substr", arguments, 2)
+			if err != nil {
+				return nil, err
+			}
+			runes := []rune(s)
+			if start < 0 || end < start || end > len(runes) {
+				return nil, logger.InterpreterError(fmt.Sprintf("Range %d to %d passed to 'substr' is out of bounds for a string of length %d.", start, end, len(runes)))
+			}
+			return string(runes[start:end]), nil
+		},
+		arity: 3,
+	})
+	// Join the elements of an array into a string, separated by sep.
+	globals.Define("join", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			array, ok := arguments[0].(*LoxArray)
+			if !ok {
+				return nil, logger.InterpreterError("First argument to 'join' must be an array.")
+			}
+			sep, ok := arguments[1].(string)
+			if !ok {
+				return nil, logger.InterpreterError("Second argument to 'join' must be a string.")
+			}
+			elements := make([]string, len(array.Elements))
+			for i, element := range array.Elements {
+				elements[i] = interpreter.stringify(element)
+			}
+			return strings.Join(elements, sep), nil
+		},
+		arity: 2,
+	})
+	// Print a value to the interpreter's error output.
+	globals.Define("eprint", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			fmt.Fprintln(interpreter.Err, interpreter.stringify(arguments[0]))
+			flush(interpreter.Err)
+			return nil, nil
+		},
+		arity: 1,
+	})
+	// Return the active function calls, innermost first.
+	globals.Define("stackTrace", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			elements := []any{}
+			for _, frame := range interpreter.stackTrace() {
+				elements = append(elements, frame)
+			}
+			return interpreter.newArray(elements), nil
+		},
+		arity: 0,
+	})
+	// Format a number in scientific notation with the given number of digits
+	// after the decimal point.
+	globals.Define("toExponential", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			number, err := numberArgument("toExponential", arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			digits, err := integerArgument("toExponential", arguments, 1)
+			if err != nil {
+				return nil, err
+			}
+			if digits < 0 || digits > 100 {
+				return nil, logger.InterpreterError("Argument 2 to 'toExponential' must be between 0 and 100.")
+			}
+			return strconv.FormatFloat(number, 'e', digits, 64), nil
+		},
+		arity: 2,
+	})
+	// Return a sorted copy of an array. Without a comparator, the elements must
+	// be all numbers or all strings. A comparator is called with two elements
+	// and returns a negative number if the first sorts before the second.
+	globals.Define("sort", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			if err := argumentCount("sort", arguments, 1, 2); err != nil {
+				return nil, err
+			}
+			array, ok := arguments[0].(*LoxArray)
+			if !ok {
+				return nil, logger.InterpreterError("Argument 1 to 'sort' must be an array.")
+			}
+			elements := make([]any, len(array.Elements))
+			copy(elements, array.Elements)
+			if len(arguments) == 2 {
+				comparator, err := callableArgument("sort", arguments, 1, 2)
+				if err != nil {
+					return nil, err
+				}
+				var callErr error
+				sort.SliceStable(elements, func(a, b int) bool {
+					if callErr != nil {
+						return false
+					}
+					result, err := comparator.Call(interpreter, []any{elements[a], elements[b]})
+					if err != nil {
+						callErr = err
+						return false
+					}
+					switch order := result.(type) {
+					case float64:
+						return order < 0
+					case *big.Rat:
+						return order.Sign() < 0
+					}
+					callErr = logger.InterpreterError("Comparator passed to 'sort' must return a number.")
+					return false
+				})
+				if callErr != nil {
+					return nil, callErr
+				}
+				return interpreter.newArray(elements), nil
+			}
+			allNumbers, allStrings := true, true
+			for _, element := range elements {
+				_, isFloat := element.(float64)
+				_, isRat := element.(*big.Rat)
+				_, isString := element.(string)
+				allNumbers = allNumbers && (isFloat || isRat)
+				allStrings = allStrings && isString
+			}
+			switch {
+			case allNumbers:
+				// In BigNumbers mode, rationals and float64s can be mixed.
+				sort.SliceStable(elements, func(a, b int) bool {
+					return compareNumbers(elements[a], elements[b]) < 0
+				})
+			case allStrings:
+				sort.SliceStable(elements, func(a, b int) bool {
+					return elements[a].(string) < elements[b].(string)
+				})
+			default:
+				return nil, logger.InterpreterError("Can only sort arrays of all numbers or all strings without a comparator.")
+			}
+			return interpreter.newArray(elements), nil
+		},
+		arity: variadic,
+	})
+	// Fold an array from the left, calling fn with the accumulator and each element.
+	globals.Define("reduce", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			array, ok := arguments[0].(*LoxArray)
+			if !ok {
+				return nil, logger.InterpreterError("Argument 1 to 'reduce' must be an array.")
+			}
+			fn, err := callableArgument("reduce", arguments, 1, 2)
+			if err != nil {
+				return nil, err
+			}
+			accumulator := arguments[2]
+			for _, element := range array.Elements {
+				accumulator, err = fn.Call(interpreter, []any{accumulator, element})
+				if err != nil {
+					return nil, err
+				}
+			}
+			return accumulator, nil
+		},
+		arity: 3,
+	})
+	// Return an array of whole numbers: range(end), range(start, end) or
+	// range(start, end, step). The end is excluded.
+	globals.Define("range", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			if err := argumentCount("range", arguments, 1, 3); err != nil {
+				return nil, err
+			}
+			bounds := []int{0, 0, 1}
+			for n := range arguments {
+				bound, err := integerArgument("range", arguments, n)
+				if err != nil {
+					return nil, err
+				}
+				bounds[n] = bound
+			}
+			start, end, step := bounds[0], bounds[1], bounds[2]
+			if len(arguments) == 1 {
+				start, end = 0, bounds[0]
+			}
+			if step <= 0 {
+				return nil, logger.InterpreterError("Step passed to 'range' must be positive.")
+			}
+			elements := []any{}
+			for n := start; n < end; n += step {
+				elements = append(elements, float64(n))
+			}
+			return interpreter.newArray(elements), nil
+		},
+		arity: variadic,
+	})
+	// Set the exit status used once the script finishes, without stopping it.
+	globals.Define("setExitCode", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			code, err := integerArgument("setExitCode", arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			if code < 0 || code > 255 {
+				return nil, logger.InterpreterError("Exit code passed to 'setExitCode' must be between 0 and 255.")
+			}
+			interpreter.exitCode = code
+			return nil, nil
+		},
+		arity: 1,
+	})
+	// End the program straight away with an optional exit status, 0 by
+	// default. Output is flushed first, but deferred expressions don't run.
+	globals.Define("exit", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			if err := argumentCount("exit", arguments, 0, 1); err != nil {
+				return nil, err
+			}
+			code := 0
+			if len(arguments) == 1 {
+				var err error
+				code, err = integerArgument("exit", arguments, 0)
+				if err != nil {
+					return nil, err
+				}
+				if code < 0 || code > 255 {
+					return nil, logger.InterpreterError("Exit code passed to 'exit' must be between 0 and 255.")
+				}
+			}
+			flush(interpreter.Out)
+			flush(interpreter.Err)
+			exit(code)
+			return nil, nil
+		},
+		arity: variadic,
+	})
+	// Replace every occurrence of old in s with new.
+	globals.Define("replace", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			return replace("replace", arguments, -1)
+		},
+		arity: 3,
+	})
+	// Replace the first n occurrences of old in s with new.
+	globals.Define("replaceN", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			n, err := integerArgument("replaceN", arguments, 3)
+			if err != nil {
+				return nil, err
+			}
+			if n < 0 {
+				return nil, logger.InterpreterError("Argument 4 to 'replaceN' must not be negative.")
+			}
+			return replace("replaceN", arguments[:3], n)
+		},
+		arity: 4,
+	})
+	// Report whether s contains a match of the regular expression pattern.
+	globals.Define("matches", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			s, err := stringArgument("matches", arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			pattern, err := interpreter.pattern("matches", arguments, 1)
+			if err != nil {
+				return nil, err
+			}
+			return pattern.MatchString(s), nil
+		},
+		arity: 2,
+	})
+	// Return an array of every match of the regular expression pattern in s.
+	globals.Define("findAll", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			s, err := stringArgument("findAll", arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			pattern, err := interpreter.pattern("findAll", arguments, 1)
+			if err != nil {
+				return nil, err
+			}
+			elements := []any{}
+			for _, match := range pattern.FindAllString(s, -1) {
+				elements = append(elements, match)
+			}
+			return interpreter.newArray(elements), nil
+		},
+		arity: 2,
+	})
+	// Count the set bits in a non-negative whole number.
+	globals.Define("bitCount", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			n, err := numberArgument("bitCount", arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			if n != math.Trunc(n) || math.IsInf(n, 0) {
+				return nil, logger.InterpreterError("Argument 1 to 'bitCount' must be a whole number.")
+			}
+			if n < 0 {
+				return nil, logger.InterpreterError("Argument 1 to 'bitCount' must not be negative.")
+			}
+			// 2^64 and above don't fit in a uint64.
+			if n >= 1<<64 {
+				return nil, logger.InterpreterError("Argument 1 to 'bitCount' must be less than 2^64.")
+			}
+			return float64(bits.OnesCount64(uint64(n))), nil
+		},
+		arity: 1,
+	})
+	// Return the greatest common divisor of two whole numbers.
+	globals.Define("gcd", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			a, b, err := integerArguments("gcd", arguments)
+			if err != nil {
+				return nil, err
+			}
+			return float64(gcd(a, b)), nil
+		},
+		arity: 2,
+	})
+	// Return the least common multiple of two whole numbers.
+	globals.Define("lcm", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			a, b, err := integerArguments("lcm", arguments)
+			if err != nil {
+				return nil, err
+			}
+			if a == 0 || b == 0 {
+				return 0.0, nil
+			}
+			// The least common multiple is never negative. It's multiplied out
+			// as a float so that large arguments can't overflow.
+			return math.Abs(float64(a/gcd(a, b)) * float64(b)), nil
+		},
+		arity: 2,
+	})
+	// Compare two values structurally, descending into arrays.
+	globals.Define("equals", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			return deepEqual(arguments[0], arguments[1], map[[2]*LoxArray]bool{}), nil
+		},
+		arity: 2,
+	})
+	// Read a line of input, or return nil at the end of the input.
+	globals.Define("readLine", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			line, ok := interpreter.readLine()
+			if !ok {
+				return nil, nil
+			}
+			return line, nil
+		},
+		arity: 0,
+	})
+	// Read a line after printing a prompt, or return nil at the end of the input.
+	globals.Define("input", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			prompt, err := stringArgument("input", arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprint(interpreter.Out, prompt)
+			flush(interpreter.Out)
+			line, ok := interpreter.readLine()
+			if !ok {
+				return nil, nil
+			}
+			return line, nil
+		},
+		arity: 1,
+	})
+	// Read a line after printing a prompt, or return nil if none arrives in time.
+	globals.Define("inputTimeout", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			prompt, err := stringArgument("inputTimeout", arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			seconds, err := numberArgument("inputTimeout", arguments, 1)
+			if err != nil {
+				return nil, err
+			}
+			if seconds < 0 {
+				return nil, logger.InterpreterError("Timeout for 'inputTimeout' must not be negative.")
+			}
+			timeout, ok := secondsToDuration(seconds)
+			if !ok {
+				return nil, logger.InterpreterError(fmt.Sprintf("Timeout for 'inputTimeout' must be at most %d seconds.", maxDurationSeconds))
+			}
+			fmt.Fprint(interpreter.Out, prompt)
+			flush(interpreter.Out)
+			line, ok := interpreter.readLineTimeout(timeout)
+			if !ok {
+				return nil, nil
+			}
+			return line, nil
+		},
+		arity: 2,
+	})
+	// Hash a string with 32-bit FNV-1a, which a float64 holds exactly.
+	globals.Define("hash", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			s, err := stringArgument("hash", arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			h := fnv.New32a()
+			h.Write([]byte(s))
+			return float64(h.Sum32()), nil
+		},
+		arity: 1,
+	})
+	// Hash a string with SHA-256, returning the digest as lowercase hex.
+	globals.Define("sha256", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			s, err := stringArgument("sha256", arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			sum := sha256.Sum256([]byte(s))
+			return hex.EncodeToString(sum[:]), nil
+		},
+		arity: 1,
+	})
+	// Encode a string as standard base64.
+	globals.Define("base64Encode", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			s, err := stringArgument("base64Encode", arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			return base64.StdEncoding.EncodeToString([]byte(s)), nil
+		},
+		arity: 1,
+	})
+	// Decode a standard base64 string, returning nil if it isn't valid.
+	globals.Define("base64Decode", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			s, err := stringArgument("base64Decode", arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			decoded, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return nil, nil
+			}
+			return string(decoded), nil
+		},
+		arity: 1,
+	})
+	// Call a function with each line of input until the input ends, or until
+	// the function returns false.
+	globals.Define("eachLine", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			fn, err := callableArgument("eachLine", arguments, 0, 1)
+			if err != nil {
+				return nil, err
+			}
+			for {
+				line, ok := interpreter.readLine()
+				if !ok {
+					return nil, nil
+				}
+				result, err := fn.Call(interpreter, []any{line})
+				if err != nil {
+					return nil, err
+				}
+				if result == false {
+					return nil, nil
+				}
+			}
+		},
+		arity: 1,
+	})
+	// Add a value to the back of an array used as a queue.
+	globals.Define("enqueue", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			array, err := arrayArgument("enqueue", arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			array.Elements = append(array.Elements, arguments[1])
+			return nil, nil
+		},
+		arity: 2,
+	})
+	// Remove and return the front of an array used as a queue, or nil if it's empty.
+	globals.Define("dequeue", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			array, err := arrayArgument("dequeue", arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			if len(array.Elements) == 0 {
+				return nil, nil
+			}
+			front := array.Elements[0]
+			array.Elements = array.Elements[1:]
+			return front, nil
+		},
+		arity: 1,
+	})
+	// Return the front of an array used as a queue without removing it, or nil if it's empty.
+	globals.Define("peek", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			array, err := arrayArgument("peek", arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			if len(array.Elements) == 0 {
+				return nil, nil
+			}
+			return array.Elements[0], nil
+		},
+		arity: 1,
+	})
+	// Format a number of seconds as a duration such as "1h2m3s".
+	globals.Define("formatDuration", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			seconds, err := numberArgument("formatDuration", arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			duration, ok := secondsToDuration(seconds)
+			if !ok {
+				return nil, logger.InterpreterError(fmt.Sprintf("Argument 1 to 'formatDuration' must be between -%d and %d.", maxDurationSeconds, maxDurationSeconds))
+			}
+			return duration.String(), nil
+		},
+		arity: 1,
+	})
+	// Parse a UTC time in one of the named layouts, returning its Unix
+	// timestamp, or nil if it doesn't match the layout.
+	globals.Define("parseTime", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			value, err := stringArgument("parseTime", arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			layout, err := layoutArgument("parseTime", arguments, 1)
+			if err != nil {
+				return nil, err
+			}
+			parsed, err := time.Parse(layout, value)
+			if err != nil {
+				return nil, nil
+			}
+			return float64(parsed.Unix()), nil
+		},
+		arity: 2,
+	})
+	// Format a Unix timestamp as UTC in one of the named layouts.
+	globals.Define("formatTime", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			timestamp, err := integerArgument("formatTime", arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			layout, err := layoutArgument("formatTime", arguments, 1)
+			if err != nil {
+				return nil, err
+			}
+			return time.Unix(int64(timestamp), 0).UTC().Format(layout), nil
+		},
+		arity: 2,
+	})
+	// Describe a value for debugging, quoting strings.
+	globals.Define("repr", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			return interpreter.repr(arguments[0]), nil
+		},
+		arity: 1,
+	})
+	// Bound a number to the range [lo, hi].
+	globals.Define("clamp", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			numbers, err := numberArguments("clamp", arguments)
+			if err != nil {
+				return nil, err
+			}
+			x, lo, hi := numbers[0], numbers[1], numbers[2]
+			if lo > hi {
+				return nil, logger.InterpreterError("Lower bound passed to 'clamp' must not be greater than the upper bound.")
+			}
+			return math.Min(math.Max(x, lo), hi), nil
+		},
+		arity: 3,
+	})
+	// Interpolate linearly from a to b. t isn't clamped, so values outside
+	// [0, 1] extrapolate.
+	globals.Define("lerp", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			numbers, err := numberArguments("lerp", arguments)
+			if err != nil {
+				return nil, err
+			}
+			a, b, t := numbers[0], numbers[1], numbers[2]
+			return a + (b-a)*t, nil
+		},
+		arity: 3,
+	})
+	// Describe the interpreter's footprint as an array of [name, count] pairs.
+	globals.Define("stats", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			depth := 0
+			for env := interpreter.environment; env.Enclosing != nil; env = env.Enclosing {
+				depth++
+			}
+			counts := []struct {
+				name  string
+				count int
+			}{
+				{"globals", len(interpreter.globals.Values)},
+				{"depth", depth},
+				{"arrays", interpreter.arraysCreated},
+			}
+			entries := make([]any, len(counts))
+			for n, count := range counts {
+				entries[n] = &LoxArray{Elements: []any{count.name, float64(count.count)}}
+			}
+			return &LoxArray{Elements: entries}, nil
+		},
+		arity: 0,
+	})
+	// Trigonometry, in radians.
+	globals.Define("sin", mathFunction("sin", math.Sin))
+	globals.Define("cos", mathFunction("cos", math.Cos))
+	globals.Define("tan", mathFunction("tan", math.Tan))
+	globals.Define("atan2", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			numbers, err := numberArguments("atan2", arguments)
+			if err != nil {
+				return nil, err
+			}
+			return math.Atan2(numbers[0], numbers[1]), nil
+		},
+		arity: 2,
+	})
+	// Convert between degrees and radians.
+	globals.Define("radians", mathFunction("radians", func(degrees float64) float64 {
+		return degrees * math.Pi / 180
+	}))
+	globals.Define("degrees", mathFunction("degrees", func(radians float64) float64 {
+		return radians * 180 / math.Pi
+	}))
+	// Generate a random version 4 UUID.
+	globals.Define("uuid", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			b := make([]byte, 16)
+			if _, err := rand.Read(b); err != nil {
+				return nil, logger.InterpreterError("Could not generate a UUID.")
+			}
+			// Set the version (4) and variant (RFC 4122) bits.
+			b[6] = b[6]&0x0f | 0x40
+			b[8] = b[8]&0x3f | 0x80
+			return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+		},
+		arity: 0,
+	})
+	// Generate n cryptographically random bytes, as a hex string. At most
+	// maxRandomBytes can be generated at once.
+	globals.Define("randomBytes", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			n, err := integerArgument("randomBytes", arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			if n < 0 {
+				return nil, logger.InterpreterError("Argument 1 to 'randomBytes' must not be negative.")
+			}
+			if n > maxRandomBytes {
+				return nil, logger.InterpreterError(fmt.Sprintf("Argument 1 to 'randomBytes' must be at most %d.", maxRandomBytes))
+			}
+			b := make([]byte, n)
+			if _, err := rand.Read(b); err != nil {
+				return nil, logger.InterpreterError("Could not generate random bytes.")
+			}
+			return hex.EncodeToString(b), nil
+		},
+		arity: 1,
+	})
+	// Splice the arrays inside an array into a new array, one level deep.
+	globals.Define("flatten", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			array, err := arrayArgument("flatten", arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			elements := []any{}
+			for _, element := range array.Elements {
+				if inner, ok := element.(*LoxArray); ok {
+					elements = append(elements, inner.Elements...)
+				} else {
+					elements = append(elements, element)
+				}
+			}
+			return interpreter.newArray(elements), nil
+		},
+		arity: 1,
+	})
+	// Splice nested arrays into a new array, however deep they go.
+	globals.Define("flattenDeep", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			array, err := arrayArgument("flattenDeep", arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			elements, err := flattenDeep(array, []any{}, map[*LoxArray]bool{})
+			if err != nil {
+				return nil, err
+			}
+			return interpreter.newArray(elements), nil
+		},
+		arity: 1,
+	})
+	// Count the non-overlapping occurrences of a substring in a string, or of a
+	// value in an array.
+	globals.Define("count", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			switch collection := arguments[0].(type) {
+			case string:
+				substring, err := stringArgument("count", arguments, 1)
+				if err != nil {
+					return nil, err
+				}
+				if substring == "" {
+					return nil, logger.InterpreterError("Argument 2 to 'count' must not be empty.")
+				}
+				return float64(strings.Count(collection, substring)), nil
+			case *LoxArray:
+				n := 0
+				for _, element := range collection.Elements {
+					if isEqual(element, arguments[1]) {
+						n++
+					}
+				}
+				return float64(n), nil
+			}
+			return nil, logger.InterpreterError("Argument 1 to 'count' must be a string or an array.")
+		},
+		arity: 2,
+	})
+	// Find the index of the first occurrence of a substring in a string, or of
+	// a value in an array, or -1 if there is none.
+	globals.Define("indexOf", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			switch collection := arguments[0].(type) {
+			case string:
+				substring, err := stringArgument("indexOf", arguments, 1)
+				if err != nil {
+					return nil, err
+				}
+				index := strings.Index(collection, substring)
+				if index < 0 {
+					return -1.0, nil
+				}
+				// Count characters rather than bytes, as chars does.
+				return float64(utf8.RuneCountInString(collection[:index])), nil
+			case *LoxArray:
+				for index, element := range collection.Elements {
+					if isEqual(element, arguments[1]) {
+						return float64(index), nil
+					}
+				}
+				return -1.0, nil
+			}
+			return nil, logger.InterpreterError("Argument 1 to 'indexOf' must be a string or an array.")
+		},
+		arity: 2,
+	})
+	// Read an environment variable, returning nil if it isn't set.
+	globals.Define("getenv", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			if !interpreter.AllowFileIO {
+				return nil, logger.InterpreterError("'getenv' is not available when file access is disabled.")
+			}
+			name, ok := arguments[0].(string)
+			if !ok {
+				return nil, logger.InterpreterError("Argument to 'getenv' must be a string.")
+			}
+			value, ok := os.LookupEnv(name)
+			if !ok {
+				return nil, nil
+			}
+			return value, nil
+		},
+		arity: 1,
+	})
+	// Return the current working directory.
+	globals.Define("cwd", NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			if !interpreter.AllowFileIO {
+				return nil, logger.InterpreterError("'cwd' is not available when file access is disabled.")
+			}
+			dir, err := os.Getwd()
+			if err != nil {
+				return nil, logger.InterpreterError(err.Error())
+			}
+			return dir, nil
+		},
+		arity: 0,
+	})
+}
+
+// replace implements the replace natives, replacing up to n occurrences, or
+// all of them if n is negative. An empty search string is an error rather
+// than inserting the replacement between every character.
+func replace(name string, arguments []any, n int) (any, error) {
+	s, err := stringArgument(name, arguments, 0)
+	if err != nil {
+		return nil, err
+	}
+	old, err := stringArgument(name, arguments, 1)
+	if err != nil {
+		return nil, err
+	}
+	replacement, err := stringArgument(name, arguments, 2)
+	if err != nil {
+		return nil, err
+	}
+	if old == "" {
+		return nil, logger.InterpreterError(fmt.Sprintf("String to replace passed to '%s' must not be empty.", name))
+	}
+	return strings.Replace(s, old, replacement, n), nil
+}
+
+// pattern compiles the regular expression passed as the argument at index,
+// reusing the result if the same pattern has been compiled before.
+func (i *Interpreter) pattern(name string, arguments []any, index int) (*regexp.Regexp, error) {
+	source, err := stringArgument(name, arguments, index)
+	if err != nil {
+		return nil, err
+	}
+	if compiled, ok := i.patterns[source]; ok {
+		return compiled, nil
+	}
+	compiled, err := regexp.Compile(source)
+	if err != nil {
+		return nil, logger.InterpreterError(fmt.Sprintf("Invalid pattern passed to '%s': %v", name, err))
+	}
+	if i.patterns == nil {
+		i.patterns = make(map[string]*regexp.Regexp)
+	}
+	i.patterns[source] = compiled
+	return compiled, nil
+}
+
+// gcd returns the greatest common divisor of the absolute values of a and b,
+// using the Euclidean algorithm.
+func gcd(a int, b int) int {
+	if a < 0 {
+		a = -a
+	}
+	if b < 0 {
+		b = -b
+	}
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// integerArguments returns the first two arguments if they are whole numbers.
+func integerArguments(name string, arguments []any) (int, int, error) {
+	a, err := integerArgument(name, arguments, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	b, err := integerArgument(name, arguments, 1)
+	if err != nil {
+		return 0, 0, err
+	}
+	return a, b, nil
+}
+
+// stringArgument returns the argument at index if it is a string.
+func stringArgument(name string, arguments []any, index int) (string, error) {
+	s, ok := arguments[index].(string)
+	if !ok {
+		return "", logger.InterpreterError(fmt.Sprintf("Argument %d to '%s' must be a string.", index+1, name))
+	}
+	return s, nil
+}
+
+// timeLayouts are the layouts accepted by the time natives, by name.
+var timeLayouts = map[string]string{
+	"date":     "2006-01-02",
+	"time":     "15:04:05",
+	"datetime": "2006-01-02 15:04:05",
+	"rfc3339":  time.RFC3339,
+}
+
+// layoutArgument returns the Go layout for the layout name at index.
+func layoutArgument(name string, arguments []any, index int) (string, error) {
+	layoutName, err := stringArgument(name, arguments, index)
+	if err != nil {
+		return "", err
+	}
+	layout, ok := timeLayouts[layoutName]
+	if !ok {
+		return "", logger.InterpreterError(fmt.Sprintf("Unknown time layout '%s' passed to '%s'.", layoutName, name))
+	}
+	return layout, nil
+}
+
+// mathFunction wraps a function of one number as a native.
+func mathFunction(name string, fn func(float64) float64) NativeFunction {
+	return NativeFunction{
+		nativeCall: func(interpreter *Interpreter, arguments []any) (any, error) {
+			number, err := numberArgument(name, arguments, 0)
+			if err != nil {
+				return nil, err
+			}
+			return fn(number), nil
+		},
+		arity: 1,
+	}
+}
+
+// numberArguments returns all the arguments if they are numbers.
+func numberArguments(name string, arguments []any) ([]float64, error) {
+	numbers := make([]float64, len(arguments))
+	for n := range arguments {
+		number, err := numberArgument(name, arguments, n)
+		if err != nil {
+			return nil, err
+		}
+		numbers[n] = number
+	}
+	return numbers, nil
+}
+
+// arrayArgument returns the argument at index if it is an array.
+func arrayArgument(name string, arguments []any, index int) (*LoxArray, error) {
+	array, ok := arguments[index].(*LoxArray)
+	if !ok {
+		return nil, logger.InterpreterError(fmt.Sprintf("Argument %d to '%s' must be an array.", index+1, name))
+	}
+	return array, nil
+}
+
+// numberArgument returns the argument at index if it is a number. Rationals
+// from BigNumbers mode are converted to the nearest float64.
+func numberArgument(name string, arguments []any, index int) (float64, error) {
+	if r, ok := arguments[index].(*big.Rat); ok {
+		number, _ := r.Float64()
+		return number, nil
+	}
+	number, ok := arguments[index].(float64)
+	if !ok {
+		return 0, logger.InterpreterError(fmt.Sprintf("Argument %d to '%s' must be a number.", index+1, name))
+	}
+	return number, nil
+}
+
+// maxDurationSeconds is the longest time.Duration, in whole seconds.
+const maxDurationSeconds = math.MaxInt64 / 1000000000
+
+// secondsToDuration converts a number of seconds to a time.Duration, or
+// reports false if it is out of range.
+func secondsToDuration(seconds float64) (time.Duration, bool) {
+	if math.IsNaN(seconds) || math.Abs(seconds) > maxDurationSeconds {
+		return 0, false
+	}
+	return time.Duration(seconds * float64(time.Second)), true
+}
+
+// integerArgument returns the argument at index if it is a whole number.
+func integerArgument(name string, arguments []any, index int) (int, error) {
+	number, err := numberArgument(name, arguments, index)
+	if err != nil {
+		return 0, err
+	}
+	if number != math.Trunc(number) || math.IsInf(number, 0) {
+		return 0, logger.InterpreterError(fmt.Sprintf("Argument %d to '%s' must be a whole number.", index+1, name))
+	}
+	// Beyond 2^53 a number can't hold every integer, and beyond 2^63 it
+	// doesn't fit in an int.
+	if math.Abs(number) > maxExactInteger {
+		return 0, logger.InterpreterError(fmt.Sprintf("Argument %d to '%s' must be between -2^53 and 2^53.", index+1, name))
+	}
+	return int(number), nil
+}
+
+// argumentCount checks that a variadic native received between min and max arguments.
+func argumentCount(name string, arguments []any, min int, max int) error {
+	if len(arguments) < min || len(arguments) > max {
+		return logger.InterpreterError(fmt.Sprintf("Expected %d to %d arguments to '%s' but got %d.", min, max, name, len(arguments)))
+	}
+	return nil
+}
+
+// callableArgument returns the argument at index if it can be called with
+// the given number of arguments.
+func callableArgument(name string, arguments []any, index int, arity int) (Callable, error) {
+	callable, ok := arguments[index].(Callable)
+	if !ok {
+		return nil, logger.InterpreterError(fmt.Sprintf("Argument %d to '%s' must be a function.", index+1, name))
+	}
+	if callable.Arity() != variadic && callable.Arity() != arity {
+		return nil, logger.InterpreterError(fmt.Sprintf("Function passed to '%s' must take %d arguments.", name, arity))
+	}
+	return callable, nil
+}
+
+// deepEqual compares arrays element by element and everything else with
+// isEqual. Pairs of arrays already being compared are assumed equal, so
+// self-referencing arrays don't recurse forever.
+func deepEqual(a, b any, visiting map[[2]*LoxArray]bool) bool {
+	left, ok := a.(*LoxArray)
+	if !ok {
+		return isEqual(a, b)
+	}
+	right, ok := b.(*LoxArray)
+	if !ok {
+		return false
+	}
+	if left == right {
+		return true
+	}
+	pair := [2]*LoxArray{left, right}
+	if visiting[pair] {
+		return true
+	}
+	if len(left.Elements) != len(right.Elements) {
+		return false
+	}
+	visiting[pair] = true
+	defer delete(visiting, pair)
+	for index := range left.Elements {
+		if !deepEqual(left.Elements[index], right.Elements[index], visiting) {
+			return false
+		}
+	}
+	return true
+}
+
+// flattenDeep appends the non-array values inside array to elements, in
+// order. An array that contains itself can't be flattened.
+func flattenDeep(array *LoxArray, elements []any, visiting map[*LoxArray]bool) ([]any, error) {
+	if visiting[array] {
+		return nil, logger.InterpreterError("Cannot flatten an array that contains itself.")
+	}
+	visiting[array] = true
+	defer delete(visiting, array)
+	for _, element := range array.Elements {
+		inner, ok := element.(*LoxArray)
+		if !ok {
+			elements = append(elements, element)
+			continue
+		}
+		var err error
+		elements, err = flattenDeep(inner, elements, visiting)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return elements, nil
+}
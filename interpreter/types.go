@@ -0,0 +1,75 @@
+package interpreter
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/lowercasename/golox/ast"
+	"github.com/lowercasename/golox/logger"
+	"github.com/lowercasename/golox/token"
+)
+
+// typeName returns the name a type annotation uses for the value's type.
+func typeName(value any) string {
+	switch value.(type) {
+	case nil:
+		return "nil"
+	case float64, *big.Rat:
+		return "number"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case *LoxClass:
+		return "class"
+	case Callable:
+		return "function"
+	case *LoxArray:
+		return "array"
+	case *Module:
+		return "module"
+	case *LoxEnum:
+		return "enum"
+	case *EnumMember:
+		return "enum member"
+	case *LoxInstance:
+		return "instance"
+	}
+	return "unknown"
+}
+
+// describeType names the value's type with an article, for messages such
+// as "tried to call a number".
+func describeType(value any) string {
+	name := typeName(value)
+	switch name[0] {
+	case 'n':
+		if value == nil {
+			return "nil"
+		}
+	case 'a', 'e', 'i', 'o', 'u':
+		return "an " + name
+	}
+	return "a " + name
+}
+
+// checkType reports an error at name if value doesn't match the annotated
+// type. Unannotated names accept anything, and nil satisfies every type so
+// that variables can be declared before they are initialized.
+func checkType(name token.Token, expected string, value any, what string) error {
+	if expected == "" || expected == "any" || value == nil {
+		return nil
+	}
+	if actual := typeName(value); actual != expected {
+		return logger.InterpreterErrorWithLineNumber(name, fmt.Sprintf("Expected %v '%v' to be %v, got %v.", what, name.Lexeme, expected, actual))
+	}
+	return nil
+}
+
+// parameterType returns the annotated type of the function's nth parameter.
+func parameterType(declaration *ast.Function, n int) string {
+	if n < len(declaration.ParameterTypes) {
+		return declaration.ParameterTypes[n]
+	}
+	return "any"
+}
@@ -25,6 +25,10 @@ func ParserError(t token.Token, message string) error {
 	}
 }
 
+func ResolverError(t token.Token, message string) error {
+	return report(t.Line, " at '"+t.Lexeme+"'", message, "Resolver")
+}
+
 func InterpreterError(message string) error {
 	return fmt.Errorf("Error: %v\n", message)
 }
@@ -32,3 +36,9 @@ func InterpreterError(message string) error {
 func InterpreterErrorWithLineNumber(t token.Token, message string) error {
 	return report(t.Line, " at '"+t.Lexeme+"'", message, "Runtime")
 }
+
+// Warning describes a problem that doesn't stop the program from running,
+// so it doesn't set HadError.
+func Warning(t token.Token, message string) error {
+	return fmt.Errorf("[line %d] Warning at '%v': %v\n", t.Line, t.Lexeme, message)
+}
@@ -2,6 +2,7 @@ package parser
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/lowercasename/golox/ast"
 	"github.com/lowercasename/golox/logger"
@@ -11,28 +12,45 @@ import (
 type Parser struct {
 	tokens  []token.Token
 	current int
+	// How many function bodies deep the parser currently is
+	functionDepth int
+	// How many loop bodies deep the parser is within the current function
+	loopDepth int
+	// How many class bodies deep the parser currently is
+	classDepth int
+	// Whether the innermost class being parsed has a superclass
+	inSubclass bool
+	// How many unbraced if branches deep the parser is within the current
+	// block. An else met there belongs to the enclosing if.
+	thenDepth int
 }
 
 func New(tokens []token.Token) Parser {
-	return Parser{tokens, 0}
+	return Parser{tokens: tokens, current: 0}
 }
 
-// Start parsing
-func (parser *Parser) Parse() []ast.Expr {
+// Parse parses the whole program. A statement that fails to parse is left
+// out, and parsing resumes at the next statement, so every error is
+// collected and returned alongside the statements that did parse.
+func (parser *Parser) Parse() ([]ast.Expr, []error) {
 	var statements []ast.Expr
+	var errors []error
 	for !parser.isAtEnd() {
 		stmt, err := parser.declaration()
 		if err != nil {
-			fmt.Println(err)
+			errors = append(errors, err)
 			parser.synchronize()
 		} else {
 			statements = append(statements, stmt)
 		}
 	}
-	return statements
+	return statements, errors
 }
 
 func (parser *Parser) declaration() (ast.Expr, error) {
+	if parser.match(token.CLASS) {
+		return parser.classDeclaration()
+	}
 	if parser.match(token.FUN) {
 		return parser.function("function")
 	}
@@ -43,6 +61,22 @@ func (parser *Parser) declaration() (ast.Expr, error) {
 		}
 		return stmt, err
 	}
+	if parser.match(token.IMPORT) {
+		return parser.importDeclaration()
+	}
+	if parser.match(token.ENUM) {
+		return parser.enumDeclaration()
+	}
+	if parser.match(token.CONST) {
+		return parser.constDeclaration(false)
+	}
+	if parser.match(token.LAZY) {
+		_, err := parser.consume(token.CONST, "Expected 'const' after 'lazy'.")
+		if err != nil {
+			return nil, err
+		}
+		return parser.constDeclaration(true)
+	}
 	return parser.statement()
 }
 
@@ -60,6 +94,7 @@ func (parser *Parser) function(kind string) (ast.Stmt, error) {
 		return nil, err
 	}
 	var parameters []token.Token
+	var parameterTypes []string
 	if !parser.check(token.RIGHT_PAREN) {
 		for {
 			if len(parameters) >= 255 {
@@ -70,6 +105,11 @@ func (parser *Parser) function(kind string) (ast.Stmt, error) {
 				return nil, err
 			}
 			parameters = append(parameters, parameter)
+			parameterType, err := parser.typeAnnotation()
+			if err != nil {
+				return nil, err
+			}
+			parameterTypes = append(parameterTypes, parameterType)
 			if !parser.match(token.COMMA) {
 				break
 			}
@@ -79,15 +119,35 @@ func (parser *Parser) function(kind string) (ast.Stmt, error) {
 	if err != nil {
 		return nil, err
 	}
+	returnType, err := parser.typeAnnotation()
+	if err != nil {
+		return nil, err
+	}
+	var guard ast.Expr = nil
+	guardText := ""
+	if parser.match(token.WHERE) {
+		start := parser.current
+		guard, err = parser.expression()
+		if err != nil {
+			return nil, err
+		}
+		guardText = parser.sourceText(start, parser.current)
+	}
 	_, err = parser.consume(token.LEFT_BRACE, fmt.Sprintf("Expected '{' before %s body.", kind))
 	if err != nil {
 		return nil, err
 	}
+	// A loop around the function doesn't extend into its body.
+	enclosingLoopDepth := parser.loopDepth
+	parser.loopDepth = 0
+	parser.functionDepth++
 	body, err := parser.block()
+	parser.functionDepth--
+	parser.loopDepth = enclosingLoopDepth
 	if err != nil {
 		return nil, err
 	}
-	return &ast.Function{Name: name, Parameters: parameters, Body: body}, nil
+	return &ast.Function{Name: name, Parameters: parameters, ParameterTypes: parameterTypes, ReturnType: returnType, Guard: guard, GuardText: guardText, Body: body}, nil
 }
 
 func (parser *Parser) statement() (ast.Stmt, error) {
@@ -127,6 +187,41 @@ func (parser *Parser) statement() (ast.Stmt, error) {
 		}
 		return stmt, nil
 	}
+	if parser.match(token.UNLESS) {
+		stmt, err := parser.unlessStatement()
+		if err != nil {
+			return nil, err
+		}
+		return stmt, nil
+	}
+	if parser.match(token.WITH) {
+		stmt, err := parser.withStatement()
+		if err != nil {
+			return nil, err
+		}
+		return stmt, nil
+	}
+	if parser.match(token.RETURN) {
+		stmt, err := parser.returnStatement()
+		if err != nil {
+			return nil, err
+		}
+		return stmt, nil
+	}
+	if parser.match(token.BREAK, token.CONTINUE) {
+		stmt, err := parser.loopControlStatement()
+		if err != nil {
+			return nil, err
+		}
+		return stmt, nil
+	}
+	if parser.match(token.DEFER) {
+		stmt, err := parser.deferStatement()
+		if err != nil {
+			return nil, err
+		}
+		return stmt, nil
+	}
 	stmt, err := parser.expressionStatement()
 	if err != nil {
 		return nil, err
@@ -135,6 +230,7 @@ func (parser *Parser) statement() (ast.Stmt, error) {
 }
 
 func (parser *Parser) forStatement() (ast.Stmt, error) {
+	keyword := parser.previous()
 	_, err := parser.consume(token.LEFT_PAREN, "Expected '(' after 'for'.")
 	if err != nil {
 		return nil, err
@@ -169,16 +265,18 @@ func (parser *Parser) forStatement() (ast.Stmt, error) {
 		}
 	}
 	parser.consume(token.RIGHT_PAREN, "Expected ')' after for loop clauses.")
+	parser.loopDepth++
 	body, err := parser.statement()
+	parser.loopDepth--
 	if err != nil {
 		return nil, err
 	}
-	if increment != nil {
-		body = &ast.Block{Statements: []ast.Stmt{body, &ast.Expression{Expression: increment}}}
-	}
-	if condition != nil {
-		body = &ast.While{Condition: condition, Body: body}
+	// A missing condition loops forever
+	if condition == nil {
+		condition = &ast.Literal{Value: true}
 	}
+	// The increment is kept apart from the body so that continue runs it.
+	body = &ast.While{Keyword: keyword, Condition: condition, Body: body, Increment: increment}
 	if initializer != nil {
 		body = &ast.Block{Statements: []ast.Stmt{initializer, body}}
 	}
@@ -198,7 +296,9 @@ func (parser *Parser) ifStatement() (ast.Stmt, error) {
 	if err != nil {
 		return nil, err
 	}
+	parser.thenDepth++
 	thenBranch, err := parser.statement()
+	parser.thenDepth--
 	if err != nil {
 		return nil, err
 	}
@@ -212,7 +312,135 @@ func (parser *Parser) ifStatement() (ast.Stmt, error) {
 	return &ast.If{Condition: condition, Then: thenBranch, Else: elseBranch}, nil
 }
 
+// unlessStatement parses `unless (condition) statement`, which runs the
+// statement only if the condition is false.
+func (parser *Parser) unlessStatement() (ast.Stmt, error) {
+	keyword := parser.previous()
+	_, err := parser.consume(token.LEFT_PAREN, "Expected '(' after 'unless'.")
+	if err != nil {
+		return nil, err
+	}
+	condition, err := parser.expression()
+	if err != nil {
+		return nil, err
+	}
+	_, err = parser.consume(token.RIGHT_PAREN, "Expected ')' after unless condition.")
+	if err != nil {
+		return nil, err
+	}
+	body, err := parser.statement()
+	if err != nil {
+		return nil, err
+	}
+	// An else here is an error unless it belongs to an enclosing if.
+	if parser.thenDepth == 0 && parser.check(token.ELSE) {
+		return nil, logger.ParserError(parser.peek(), "Cannot use 'else' with 'unless'.")
+	}
+	return &ast.If{Condition: negate(keyword, condition), Then: body}, nil
+}
+
+// negate wraps a condition in a logical not, reported at the given token.
+func negate(at token.Token, condition ast.Expr) ast.Expr {
+	operator := token.Token{Type: token.BANG, Lexeme: "!", Line: at.Line, Column: at.Column}
+	return &ast.Unary{Operator: operator, Right: condition}
+}
+
+func (parser *Parser) withStatement() (ast.Stmt, error) {
+	keyword := parser.previous()
+	_, err := parser.consume(token.LEFT_PAREN, "Expected '(' after 'with'.")
+	if err != nil {
+		return nil, err
+	}
+	_, err = parser.consume(token.VAR, "Expected 'var' after 'with ('.")
+	if err != nil {
+		return nil, err
+	}
+	name, err := parser.consume(token.IDENTIFIER, "Expected resource name.")
+	if err != nil {
+		return nil, err
+	}
+	_, err = parser.consume(token.EQUAL, "Expected '=' after resource name.")
+	if err != nil {
+		return nil, err
+	}
+	initializer, err := parser.expression()
+	if err != nil {
+		return nil, err
+	}
+	_, err = parser.consume(token.RIGHT_PAREN, "Expected ')' after resource.")
+	if err != nil {
+		return nil, err
+	}
+	body, err := parser.statement()
+	if err != nil {
+		return nil, err
+	}
+	return &ast.With{Keyword: keyword, Name: name, Initializer: initializer, Body: body}, nil
+}
+
+func (parser *Parser) returnStatement() (ast.Stmt, error) {
+	keyword := parser.previous()
+	if parser.functionDepth == 0 {
+		return nil, logger.ParserError(keyword, "Cannot return from top-level code.")
+	}
+	var value ast.Expr = nil
+	if !parser.check(token.SEMICOLON) && !parser.check(token.UNLESS) {
+		var err error
+		value, err = parser.expression()
+		if err != nil {
+			return nil, err
+		}
+	}
+	var stmt ast.Stmt = &ast.Return{Keyword: keyword, Value: value}
+	// `return value unless condition;` only returns if the condition is false.
+	if parser.match(token.UNLESS) {
+		unless := parser.previous()
+		condition, err := parser.expression()
+		if err != nil {
+			return nil, err
+		}
+		stmt = &ast.If{Condition: negate(unless, condition), Then: stmt}
+	}
+	_, err := parser.consume(token.SEMICOLON, "Expected ';' after return value.")
+	if err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+func (parser *Parser) loopControlStatement() (ast.Stmt, error) {
+	keyword := parser.previous()
+	if parser.loopDepth == 0 {
+		return nil, logger.ParserError(keyword, fmt.Sprintf("Cannot use '%s' outside of a loop.", keyword.Lexeme))
+	}
+	_, err := parser.consume(token.SEMICOLON, fmt.Sprintf("Expected ';' after '%s'.", keyword.Lexeme))
+	if err != nil {
+		return nil, err
+	}
+	if keyword.Type == token.BREAK {
+		return &ast.Break{Keyword: keyword}, nil
+	}
+	return &ast.Continue{Keyword: keyword}, nil
+}
+
+func (parser *Parser) deferStatement() (ast.Stmt, error) {
+	keyword := parser.previous()
+	if parser.functionDepth == 0 {
+		return nil, logger.ParserError(keyword, "Cannot use 'defer' outside of a function.")
+	}
+	expr, err := parser.expression()
+	if err != nil {
+		return nil, err
+	}
+	_, err = parser.consume(token.SEMICOLON, "Expected ';' after deferred expression.")
+	if err != nil {
+		return nil, err
+	}
+	return &ast.Defer{Keyword: keyword, Expression: expr}, nil
+}
+
 func (parser *Parser) printStatement() (ast.Stmt, error) {
+	keyword := parser.previous()
 	value, err := parser.expression()
 	if err != nil {
 		return nil, err
@@ -221,7 +449,7 @@ func (parser *Parser) printStatement() (ast.Stmt, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &ast.Print{Expression: value}, nil
+	return &ast.Print{Keyword: keyword, Expression: value}, nil
 }
 
 func (parser *Parser) varDeclaration() (ast.Stmt, error) {
@@ -229,6 +457,10 @@ func (parser *Parser) varDeclaration() (ast.Stmt, error) {
 	if err != nil {
 		return nil, err
 	}
+	variableType, err := parser.typeAnnotation()
+	if err != nil {
+		return nil, err
+	}
 	var initializer ast.Expr = nil
 	if parser.match(token.EQUAL) {
 		initializer, err = parser.expression()
@@ -240,10 +472,145 @@ func (parser *Parser) varDeclaration() (ast.Stmt, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &ast.Var{Name: name, Initializer: initializer}, nil
+	return &ast.Var{Name: name, Type: variableType, Initializer: initializer}, nil
+}
+
+// Type names that can follow ':' in an annotation.
+var typeNames = map[string]bool{
+	"number":   true,
+	"string":   true,
+	"boolean":  true,
+	"function": true,
+	"any":      true,
+}
+
+// typeAnnotation parses an optional `: type` annotation, returning "any" if
+// there isn't one.
+func (parser *Parser) typeAnnotation() (string, error) {
+	if !parser.match(token.COLON) {
+		return "any", nil
+	}
+	name, err := parser.consume(token.IDENTIFIER, "Expected type name after ':'.")
+	if err != nil {
+		return "", err
+	}
+	if !typeNames[name.Lexeme] {
+		return "", logger.ParserError(name, "Unknown type '"+name.Lexeme+"'.")
+	}
+	return name.Lexeme, nil
+}
+
+func (parser *Parser) constDeclaration(lazy bool) (ast.Stmt, error) {
+	stmt, err := parser.varDeclaration()
+	if err != nil {
+		return nil, err
+	}
+	declaration := stmt.(*ast.Var)
+	if declaration.Initializer == nil {
+		return nil, logger.ParserError(declaration.Name, "Constant '"+declaration.Name.Lexeme+"' must be initialized.")
+	}
+	declaration.Constant = true
+	declaration.Lazy = lazy
+	return declaration, nil
+}
+
+func (parser *Parser) enumDeclaration() (ast.Stmt, error) {
+	name, err := parser.consume(token.IDENTIFIER, "Expected enum name.")
+	if err != nil {
+		return nil, err
+	}
+	_, err = parser.consume(token.LEFT_BRACE, "Expected '{' after enum name.")
+	if err != nil {
+		return nil, err
+	}
+	var members []token.Token
+	seen := map[string]bool{}
+	for !parser.check(token.RIGHT_BRACE) && !parser.isAtEnd() {
+		member, err := parser.consume(token.IDENTIFIER, "Expected enum member name.")
+		if err != nil {
+			return nil, err
+		}
+		if seen[member.Lexeme] {
+			return nil, logger.ParserError(member, "Duplicate enum member '"+member.Lexeme+"'.")
+		}
+		seen[member.Lexeme] = true
+		members = append(members, member)
+		if !parser.match(token.COMMA) {
+			break
+		}
+	}
+	_, err = parser.consume(token.RIGHT_BRACE, "Expected '}' after enum members.")
+	if err != nil {
+		return nil, err
+	}
+	return &ast.Enum{Name: name, Members: members}, nil
+}
+
+func (parser *Parser) classDeclaration() (ast.Stmt, error) {
+	name, err := parser.consume(token.IDENTIFIER, "Expected class name.")
+	if err != nil {
+		return nil, err
+	}
+	var superclass *ast.Variable
+	if parser.match(token.LESS) {
+		superName, err := parser.consume(token.IDENTIFIER, "Expected superclass name.")
+		if err != nil {
+			return nil, err
+		}
+		if superName.Lexeme == name.Lexeme {
+			return nil, logger.ParserError(superName, "A class cannot inherit from itself.")
+		}
+		superclass = &ast.Variable{Name: superName}
+	}
+	_, err = parser.consume(token.LEFT_BRACE, "Expected '{' before class body.")
+	if err != nil {
+		return nil, err
+	}
+	var methods []*ast.Function
+	enclosingSubclass := parser.inSubclass
+	parser.inSubclass = superclass != nil
+	parser.classDepth++
+	defer func() {
+		parser.inSubclass = enclosingSubclass
+		parser.classDepth--
+	}()
+	for !parser.check(token.RIGHT_BRACE) && !parser.isAtEnd() {
+		method, err := parser.function("method")
+		if err != nil {
+			return nil, err
+		}
+		methods = append(methods, method.(*ast.Function))
+	}
+	_, err = parser.consume(token.RIGHT_BRACE, "Expected '}' after class body.")
+	if err != nil {
+		return nil, err
+	}
+	return &ast.Class{Name: name, Superclass: superclass, Methods: methods}, nil
+}
+
+func (parser *Parser) importDeclaration() (ast.Stmt, error) {
+	keyword := parser.previous()
+	path, err := parser.consume(token.STRING, "Expected module path after 'import'.")
+	if err != nil {
+		return nil, err
+	}
+	var alias *token.Token = nil
+	if parser.match(token.AS) {
+		name, err := parser.consume(token.IDENTIFIER, "Expected module name after 'as'.")
+		if err != nil {
+			return nil, err
+		}
+		alias = &name
+	}
+	_, err = parser.consume(token.SEMICOLON, "Expected ';' after import.")
+	if err != nil {
+		return nil, err
+	}
+	return &ast.Import{Keyword: keyword, Path: path, Alias: alias}, nil
 }
 
 func (parser *Parser) whileStatement() (ast.Stmt, error) {
+	keyword := parser.previous()
 	_, err := parser.consume(token.LEFT_PAREN, "Expected '(' after 'while'.")
 	if err != nil {
 		return nil, err
@@ -256,11 +623,13 @@ func (parser *Parser) whileStatement() (ast.Stmt, error) {
 	if err != nil {
 		return nil, err
 	}
+	parser.loopDepth++
 	body, err := parser.statement()
+	parser.loopDepth--
 	if err != nil {
 		return nil, err
 	}
-	return &ast.While{Condition: condition, Body: body}, nil
+	return &ast.While{Keyword: keyword, Condition: condition, Body: body}, nil
 }
 
 func (parser *Parser) expressionStatement() (ast.Stmt, error) {
@@ -276,6 +645,10 @@ func (parser *Parser) expressionStatement() (ast.Stmt, error) {
 }
 
 func (parser *Parser) block() ([]ast.Stmt, error) {
+	// An if around the block can't take an else from inside it.
+	enclosingThenDepth := parser.thenDepth
+	parser.thenDepth = 0
+	defer func() { parser.thenDepth = enclosingThenDepth }()
 	var statements []ast.Stmt
 	for !parser.check(token.RIGHT_BRACE) && !parser.isAtEnd() {
 		stmt, err := parser.declaration()
@@ -293,7 +666,7 @@ func (parser *Parser) block() ([]ast.Stmt, error) {
 
 func (parser *Parser) assignment() (ast.Expr, error) {
 	// Evaluate the l-value
-	expr, err := parser.or()
+	expr, err := parser.ternary()
 	if err != nil {
 		return nil, err
 	}
@@ -309,12 +682,76 @@ func (parser *Parser) assignment() (ast.Expr, error) {
 		switch expr := expr.(type) {
 		case *ast.Variable:
 			return &ast.Assign{Name: expr.Name, Value: value}, nil
+		case *ast.Get:
+			return &ast.Set{Object: expr.Object, Name: expr.Name, Value: value}, nil
+		case *ast.Index:
+			return &ast.SetIndex{Object: expr.Object, Bracket: expr.Bracket, Index: expr.Index, Value: value}, nil
 		}
 		return nil, logger.ParserError(equals, "Invalid assignment target.")
 	}
+	if parser.match(token.COLON_EQUAL) {
+		walrus := parser.previous()
+		value, err := parser.assignment()
+		if err != nil {
+			return nil, err
+		}
+		if variable, ok := expr.(*ast.Variable); ok {
+			return &ast.Assign{Name: variable.Name, Value: value, Declare: true}, nil
+		}
+		return nil, logger.ParserError(walrus, "Invalid declaration target.")
+	}
+	if parser.match(token.PLUS_EQUAL, token.MINUS_EQUAL, token.STAR_EQUAL, token.SLASH_EQUAL) {
+		compound := parser.previous()
+		value, err := parser.assignment()
+		if err != nil {
+			return nil, err
+		}
+		variable, ok := expr.(*ast.Variable)
+		if !ok {
+			return nil, logger.ParserError(compound, "Invalid assignment target.")
+		}
+		// x += y is shorthand for x = x + y.
+		operator := compound
+		operator.Lexeme = compound.Lexeme[:1]
+		operator.Type = compoundOperators[compound.Type]
+		return &ast.Assign{Name: variable.Name, Value: &ast.Binary{Left: variable, Operator: operator, Right: value}}, nil
+	}
 	return expr, nil
 }
 
+// compoundOperators maps each compound assignment to the operator it applies.
+var compoundOperators = map[token.Type]token.Type{
+	token.PLUS_EQUAL:  token.PLUS,
+	token.MINUS_EQUAL: token.MINUS,
+	token.STAR_EQUAL:  token.STAR,
+	token.SLASH_EQUAL: token.SLASH,
+}
+
+// ternary parses `condition ? then : else`. The else branch may itself be a
+// ternary, so the operator associates to the right.
+func (parser *Parser) ternary() (ast.Expr, error) {
+	expr, err := parser.or()
+	if err != nil {
+		return nil, err
+	}
+	if !parser.match(token.QMARK) {
+		return expr, nil
+	}
+	thenBranch, err := parser.expression()
+	if err != nil {
+		return nil, err
+	}
+	_, err = parser.consume(token.COLON, "Expected ':' after then branch of conditional expression.")
+	if err != nil {
+		return nil, err
+	}
+	elseBranch, err := parser.ternary()
+	if err != nil {
+		return nil, err
+	}
+	return &ast.Ternary{Condition: expr, Then: thenBranch, Else: elseBranch}, nil
+}
+
 func (parser *Parser) or() (ast.Expr, error) {
 	expr, err := parser.and()
 	if err != nil {
@@ -436,6 +873,23 @@ func (parser *Parser) call() (ast.Expr, error) {
 			if err != nil {
 				return nil, err
 			}
+		} else if parser.match(token.DOT) {
+			name, err := parser.consume(token.IDENTIFIER, "Expected property name after '.'.")
+			if err != nil {
+				return nil, err
+			}
+			expr = &ast.Get{Object: expr, Name: name}
+		} else if parser.match(token.LEFT_BRACKET) {
+			bracket := parser.previous()
+			index, err := parser.expression()
+			if err != nil {
+				return nil, err
+			}
+			_, err = parser.consume(token.RIGHT_BRACKET, "Expected ']' after index.")
+			if err != nil {
+				return nil, err
+			}
+			expr = &ast.Index{Object: expr, Bracket: bracket, Index: index}
 		} else {
 			break
 		}
@@ -443,6 +897,27 @@ func (parser *Parser) call() (ast.Expr, error) {
 	return expr, nil
 }
 
+// listLiteral parses the elements of [a, b, c], which may end with a comma.
+func (parser *Parser) listLiteral() (ast.Expr, error) {
+	bracket := parser.previous()
+	var elements []ast.Expr
+	for !parser.check(token.RIGHT_BRACKET) && !parser.isAtEnd() {
+		element, err := parser.expression()
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, element)
+		if !parser.match(token.COMMA) {
+			break
+		}
+	}
+	_, err := parser.consume(token.RIGHT_BRACKET, "Expected ']' after list elements.")
+	if err != nil {
+		return nil, err
+	}
+	return &ast.ListLiteral{Bracket: bracket, Elements: elements}, nil
+}
+
 func (parser *Parser) finishCall(callee ast.Expr) (ast.Expr, error) {
 	var arguments []ast.Expr
 	if !parser.check(token.RIGHT_PAREN) {
@@ -450,10 +925,15 @@ func (parser *Parser) finishCall(callee ast.Expr) (ast.Expr, error) {
 			if len(arguments) >= 255 {
 				return nil, logger.ParserError(parser.peek(), "Cannot have more than 255 arguments.")
 			}
+			spread := parser.match(token.ELLIPSIS)
+			operator := parser.previous()
 			argument, err := parser.expression()
 			if err != nil {
 				return nil, err
 			}
+			if spread {
+				argument = &ast.Spread{Operator: operator, Expression: argument}
+			}
 			arguments = append(arguments, argument)
 			if !parser.match(token.COMMA) {
 				break
@@ -491,8 +971,43 @@ func (parser *Parser) primary() (ast.Expr, error) {
 		}
 		return &ast.Grouping{Expression: expr}, nil
 	}
+	if parser.match(token.LEFT_BRACKET) {
+		return parser.listLiteral()
+	}
+	if parser.match(token.SUPER) {
+		keyword := parser.previous()
+		if parser.classDepth == 0 {
+			return nil, logger.ParserError(keyword, "Cannot use 'super' outside of a class.")
+		}
+		if !parser.inSubclass {
+			return nil, logger.ParserError(keyword, "Cannot use 'super' in a class with no superclass.")
+		}
+		_, err := parser.consume(token.DOT, "Expected '.' after 'super'.")
+		if err != nil {
+			return nil, err
+		}
+		method, err := parser.consume(token.IDENTIFIER, "Expected superclass method name.")
+		if err != nil {
+			return nil, err
+		}
+		return &ast.Super{Keyword: keyword, Method: method}, nil
+	}
+	if parser.match(token.THIS) {
+		keyword := parser.previous()
+		if parser.classDepth == 0 {
+			return nil, logger.ParserError(keyword, "Cannot use 'this' outside of a class.")
+		}
+		return &ast.This{Keyword: keyword}, nil
+	}
 	if parser.match(token.IDENTIFIER) {
-		return &ast.Variable{Name: parser.previous()}, nil
+		name := parser.previous()
+		// The throwaway variable _ can be assigned to but never read. An
+		// assignment target is parsed as a variable first, so only reject
+		// it here if it isn't followed by an '=' or ':='.
+		if name.Lexeme == "_" && !parser.check(token.EQUAL) && !parser.check(token.COLON_EQUAL) {
+			return nil, logger.ParserError(name, "Cannot read from '_'.")
+		}
+		return &ast.Variable{Name: name}, nil
 	}
 	// No match!
 	return nil, logger.ParserError(parser.peek(), "Expected expression.")
@@ -543,6 +1058,16 @@ func (parser *Parser) previous() token.Token {
 	return parser.tokens[parser.current-1]
 }
 
+// sourceText approximates the source of the tokens from start up to (but not
+// including) end by joining their lexemes.
+func (parser *Parser) sourceText(start int, end int) string {
+	lexemes := make([]string, 0, end-start)
+	for _, t := range parser.tokens[start:end] {
+		lexemes = append(lexemes, t.Lexeme)
+	}
+	return strings.Join(lexemes, " ")
+}
+
 func (parser *Parser) synchronize() {
 	parser.advance()
 
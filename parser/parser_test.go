@@ -0,0 +1,55 @@
+package parser
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/lowercasename/golox/scanner"
+)
+
+func TestParseErrors(t *testing.T) {
+	// Errors are returned rather than printed.
+	stdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = w
+	tokens, _ := scanner.Tokenize("var a = ;\nprint a;\nprint 1 +;")
+	parser := New(tokens)
+	statements, errors := parser.Parse()
+	os.Stdout = stdout
+	w.Close()
+	printed, _ := io.ReadAll(r)
+	if len(printed) != 0 {
+		t.Fatalf("expected nothing to be printed, got=%q", printed)
+	}
+
+	expected := []string{
+		"[line 1] ParserError at ';': Expected expression.\n",
+		"[line 3] ParserError at ';': Expected expression.\n",
+	}
+	if len(errors) != len(expected) {
+		t.Fatalf("expected=%d errors, got=%q", len(expected), errors)
+	}
+	for i, err := range errors {
+		if err.Error() != expected[i] {
+			t.Fatalf("expected=%q, got=%q", expected[i], err.Error())
+		}
+	}
+	// The statements that did parse are still returned.
+	if len(statements) != 1 || statements[0].String() != "(print a)" {
+		t.Fatalf("expected=(print a), got=%v", statements)
+	}
+}
+
+func TestTopLevelReturn(t *testing.T) {
+	tokens, _ := scanner.Tokenize("return 5;")
+	parser := New(tokens)
+	_, errors := parser.Parse()
+	expected := "[line 1] ParserError at 'return': Cannot return from top-level code.\n"
+	if len(errors) != 1 || errors[0].Error() != expected {
+		t.Fatalf("expected=%q, got=%q", expected, errors)
+	}
+}
@@ -0,0 +1,496 @@
+package resolver
+
+import (
+	"fmt"
+
+	"github.com/lowercasename/golox/ast"
+	"github.com/lowercasename/golox/logger"
+	"github.com/lowercasename/golox/token"
+)
+
+// Resolver walks a parsed program before it runs, tracking which names are
+// declared in which scope, and reports warnings about suspicious code.
+type Resolver struct {
+	// WarnShadow reports local declarations that shadow a variable declared
+	// in an enclosing local scope.
+	WarnShadow bool
+	// ShadowGlobals also reports locals that shadow a global.
+	ShadowGlobals bool
+	// ShadowParameters also reports parameters that shadow an enclosing
+	// variable.
+	ShadowParameters bool
+	// WarnUnreachable reports statements that follow a loop which never
+	// finishes.
+	WarnUnreachable bool
+	// WarnUnused reports local variables that are never read.
+	WarnUnused bool
+	// UnusedErrors reports unused locals as errors rather than warnings.
+	UnusedErrors bool
+
+	// The names declared in each local scope, innermost last
+	scopes []*scope
+	// Declaration lines of the global names seen so far
+	globals  map[string]int
+	warnings []error
+	hadError bool
+	// The kind of function whose body is being resolved
+	function functionKind
+}
+
+// functionKind says what sort of function a return statement is in.
+type functionKind int
+
+const (
+	noFunction functionKind = iota
+	plainFunction
+	method
+	initializer
+)
+
+// scope holds the names declared in a block or function body.
+type scope struct {
+	names map[string]*local
+	// Every local in the order it was declared, including ones that were
+	// later redeclared, so unused ones are reported in source order
+	declared []*local
+}
+
+// local is a name declared in a local scope.
+type local struct {
+	name token.Token
+	// Only variables are reported when unused; parameters, functions and
+	// the like are not.
+	variable bool
+	used     bool
+	// False while the variable's initializer is being resolved
+	defined bool
+}
+
+// New creates a resolver with every warning turned off.
+func New() *Resolver {
+	return &Resolver{globals: map[string]int{}}
+}
+
+// Resolve walks the statements and returns the warnings and errors found,
+// in the order they were found.
+func (r *Resolver) Resolve(statements []ast.Expr) []error {
+	r.warnings = nil
+	r.hadError = false
+	stmts := make([]ast.Stmt, len(statements))
+	for n, statement := range statements {
+		stmts[n] = statement
+	}
+	r.resolveStatements(stmts)
+	return r.warnings
+}
+
+// HadError reports whether the last call to Resolve found any errors, as
+// opposed to only warnings.
+func (r *Resolver) HadError() bool {
+	return r.hadError
+}
+
+func (r *Resolver) resolveStatements(statements []ast.Stmt) {
+	var blockedBy *ast.While
+	for _, statement := range statements {
+		// Only the first unreachable statement is reported, not every one
+		// after it.
+		if blockedBy != nil && r.WarnUnreachable {
+			name, ok := statementToken(statement)
+			if !ok {
+				name = blockedBy.Keyword
+			}
+			r.warn(name, fmt.Sprintf("Unreachable code after the infinite loop on line %d.", blockedBy.Keyword.Line))
+			blockedBy = nil
+		}
+		r.resolve(statement)
+		if loop := infiniteLoop(statement); loop != nil {
+			blockedBy = loop
+		}
+	}
+}
+
+func (r *Resolver) resolve(statement ast.Stmt) {
+	switch stmt := statement.(type) {
+	case *ast.Var:
+		// The variable is declared before its initializer is resolved, so
+		// the initializer can't read it by mistake instead of an outer
+		// variable with the same name.
+		declared := r.declare(stmt.Name, false)
+		declared.variable, declared.defined = true, false
+		if stmt.Initializer != nil {
+			r.resolveExpression(stmt.Initializer)
+		}
+		declared.defined = true
+	case *ast.Function:
+		r.declare(stmt.Name, false)
+		r.resolveFunction(stmt, plainFunction)
+	case *ast.Block:
+		r.beginScope()
+		r.resolveStatements(stmt.Statements)
+		r.endScope()
+	case *ast.Expression:
+		r.resolveExpression(stmt.Expression)
+	case *ast.Print:
+		r.resolveExpression(stmt.Expression)
+	case *ast.Defer:
+		r.resolveExpression(stmt.Expression)
+	case *ast.Return:
+		// The parser already rejects these, but a program built some other
+		// way might not have been checked.
+		if r.function == noFunction {
+			r.error(stmt.Keyword, "Cannot return from top-level code.")
+		}
+		if stmt.Value != nil {
+			if r.function == initializer {
+				r.error(stmt.Keyword, "Cannot return a value from an initializer.")
+			}
+			r.resolveExpression(stmt.Value)
+		}
+	case *ast.If:
+		r.resolveExpression(stmt.Condition)
+		r.resolve(stmt.Then)
+		if stmt.Else != nil {
+			r.resolve(stmt.Else)
+		}
+	case *ast.While:
+		r.resolveExpression(stmt.Condition)
+		r.resolve(stmt.Body)
+		if stmt.Increment != nil {
+			r.resolveExpression(stmt.Increment)
+		}
+	case *ast.Enum:
+		r.declare(stmt.Name, false)
+	case *ast.Class:
+		r.declare(stmt.Name, false)
+		if stmt.Superclass != nil {
+			r.resolveExpression(stmt.Superclass)
+		}
+		for _, function := range stmt.Methods {
+			kind := method
+			if function.Name.Lexeme == "init" {
+				kind = initializer
+			}
+			r.resolveFunction(function, kind)
+		}
+	case *ast.With:
+		r.resolveExpression(stmt.Initializer)
+		r.beginScope()
+		r.declare(stmt.Name, false)
+		r.resolve(stmt.Body)
+		r.endScope()
+	case *ast.Import:
+		if stmt.Alias != nil {
+			r.declare(*stmt.Alias, false)
+		}
+	}
+}
+
+// resolveFunction resolves a function's parameters and body in a scope of
+// their own.
+func (r *Resolver) resolveFunction(function *ast.Function, kind functionKind) {
+	enclosing := r.function
+	r.function = kind
+	defer func() { r.function = enclosing }()
+	r.beginScope()
+	for _, param := range function.Parameters {
+		r.declare(param, true)
+	}
+	if function.Guard != nil {
+		r.resolveExpression(function.Guard)
+	}
+	r.resolveStatements(function.Body)
+	r.endScope()
+}
+
+// resolveExpression walks an expression, marking the locals it reads as
+// used.
+func (r *Resolver) resolveExpression(expr ast.Expr) {
+	switch e := expr.(type) {
+	case *ast.Variable:
+		if local, ok := r.lookup(e.Name.Lexeme); ok {
+			if !local.defined {
+				r.error(e.Name, "Can't read local variable in its own initializer.")
+			}
+			local.used = true
+		}
+	case *ast.Assign:
+		// Assigning to a variable doesn't count as using it.
+		r.resolveExpression(e.Value)
+	case *ast.Binary:
+		r.resolveExpression(e.Left)
+		r.resolveExpression(e.Right)
+	case *ast.Logical:
+		r.resolveExpression(e.Left)
+		r.resolveExpression(e.Right)
+	case *ast.Unary:
+		r.resolveExpression(e.Right)
+	case *ast.Grouping:
+		r.resolveExpression(e.Expression)
+	case *ast.Call:
+		r.resolveExpression(e.Callee)
+		for _, argument := range e.Arguments {
+			r.resolveExpression(argument)
+		}
+	case *ast.Spread:
+		r.resolveExpression(e.Expression)
+	case *ast.Get:
+		r.resolveExpression(e.Object)
+	case *ast.Set:
+		r.resolveExpression(e.Object)
+		r.resolveExpression(e.Value)
+	case *ast.Ternary:
+		r.resolveExpression(e.Condition)
+		r.resolveExpression(e.Then)
+		r.resolveExpression(e.Else)
+	case *ast.ListLiteral:
+		for _, element := range e.Elements {
+			r.resolveExpression(element)
+		}
+	case *ast.Index:
+		r.resolveExpression(e.Object)
+		r.resolveExpression(e.Index)
+	case *ast.SetIndex:
+		r.resolveExpression(e.Object)
+		r.resolveExpression(e.Index)
+		r.resolveExpression(e.Value)
+	}
+}
+
+// infiniteLoop returns the loop that stops the statement from ever
+// finishing, or nil if it can finish normally. Loops end when their
+// condition is false or they break, so a loop on a literal true with no
+// break runs forever.
+func infiniteLoop(statement ast.Stmt) *ast.While {
+	switch stmt := statement.(type) {
+	case *ast.While:
+		if literal, ok := unwrap(stmt.Condition).(*ast.Literal); ok && literal.Value == true && !breaks(stmt.Body) {
+			return stmt
+		}
+	case *ast.Block:
+		for _, statement := range stmt.Statements {
+			if loop := infiniteLoop(statement); loop != nil {
+				return loop
+			}
+		}
+	case *ast.If:
+		if stmt.Else != nil {
+			thenLoop, elseLoop := infiniteLoop(stmt.Then), infiniteLoop(stmt.Else)
+			if thenLoop != nil && elseLoop != nil {
+				return thenLoop
+			}
+		}
+	}
+	return nil
+}
+
+// breaks reports whether the statement contains a break out of the loop
+// whose body it is. Breaks inside nested loops and functions don't count.
+func breaks(statement ast.Stmt) bool {
+	switch stmt := statement.(type) {
+	case *ast.Break:
+		return true
+	case *ast.Block:
+		for _, statement := range stmt.Statements {
+			if breaks(statement) {
+				return true
+			}
+		}
+	case *ast.If:
+		return breaks(stmt.Then) || (stmt.Else != nil && breaks(stmt.Else))
+	case *ast.With:
+		return breaks(stmt.Body)
+	}
+	return false
+}
+
+// unwrap strips any grouping parentheses from an expression.
+func unwrap(expr ast.Expr) ast.Expr {
+	for {
+		grouping, ok := expr.(*ast.Grouping)
+		if !ok {
+			return expr
+		}
+		expr = grouping.Expression
+	}
+}
+
+// statementToken finds a token to report a warning about a statement at.
+func statementToken(statement ast.Stmt) (token.Token, bool) {
+	switch stmt := statement.(type) {
+	case *ast.Print:
+		return stmt.Keyword, true
+	case *ast.While:
+		return stmt.Keyword, true
+	case *ast.Var:
+		return stmt.Name, true
+	case *ast.Function:
+		return stmt.Name, true
+	case *ast.Enum:
+		return stmt.Name, true
+	case *ast.Class:
+		return stmt.Name, true
+	case *ast.Import:
+		return stmt.Keyword, true
+	case *ast.With:
+		return stmt.Keyword, true
+	case *ast.Defer:
+		return stmt.Keyword, true
+	case *ast.Return:
+		return stmt.Keyword, true
+	case *ast.Break:
+		return stmt.Keyword, true
+	case *ast.Continue:
+		return stmt.Keyword, true
+	case *ast.Expression:
+		return expressionToken(stmt.Expression)
+	case *ast.If:
+		return expressionToken(stmt.Condition)
+	case *ast.Block:
+		for _, statement := range stmt.Statements {
+			if name, ok := statementToken(statement); ok {
+				return name, true
+			}
+		}
+	}
+	return token.Token{}, false
+}
+
+// expressionToken finds the leftmost token in an expression.
+func expressionToken(expr ast.Expr) (token.Token, bool) {
+	switch e := expr.(type) {
+	case *ast.Assign:
+		return e.Name, true
+	case *ast.Variable:
+		return e.Name, true
+	case *ast.This:
+		return e.Keyword, true
+	case *ast.Super:
+		return e.Keyword, true
+	case *ast.Unary:
+		return e.Operator, true
+	case *ast.Binary:
+		if name, ok := expressionToken(e.Left); ok {
+			return name, true
+		}
+		return e.Operator, true
+	case *ast.Logical:
+		if name, ok := expressionToken(e.Left); ok {
+			return name, true
+		}
+		return e.Operator, true
+	case *ast.Call:
+		if name, ok := expressionToken(e.Callee); ok {
+			return name, true
+		}
+		return e.Paren, true
+	case *ast.Get:
+		if name, ok := expressionToken(e.Object); ok {
+			return name, true
+		}
+		return e.Name, true
+	case *ast.Set:
+		if name, ok := expressionToken(e.Object); ok {
+			return name, true
+		}
+		return e.Name, true
+	case *ast.Ternary:
+		return expressionToken(e.Condition)
+	case *ast.ListLiteral:
+		return e.Bracket, true
+	case *ast.Index:
+		if name, ok := expressionToken(e.Object); ok {
+			return name, true
+		}
+		return e.Bracket, true
+	case *ast.SetIndex:
+		if name, ok := expressionToken(e.Object); ok {
+			return name, true
+		}
+		return e.Bracket, true
+	case *ast.Grouping:
+		return expressionToken(e.Expression)
+	}
+	return token.Token{}, false
+}
+
+func (r *Resolver) beginScope() {
+	r.scopes = append(r.scopes, &scope{names: map[string]*local{}})
+}
+
+// endScope leaves the innermost scope, reporting any variables declared in
+// it that were never read.
+func (r *Resolver) endScope() {
+	if r.WarnUnused {
+		for _, local := range r.scopes[len(r.scopes)-1].declared {
+			if local.variable && !local.used {
+				message := fmt.Sprintf("Local variable '%v' is never used.", local.name.Lexeme)
+				if r.UnusedErrors {
+					r.error(local.name, message)
+				} else {
+					r.warn(local.name, message)
+				}
+			}
+		}
+	}
+	r.scopes = r.scopes[:len(r.scopes)-1]
+}
+
+// declare records a name in the innermost scope, warning if it shadows a
+// name from an enclosing one. Globals and the name '_' aren't tracked, so
+// the local returned for them is thrown away.
+func (r *Resolver) declare(name token.Token, parameter bool) *local {
+	declared := &local{name: name, defined: true}
+	if name.Lexeme == "_" {
+		return declared
+	}
+	if len(r.scopes) == 0 {
+		r.globals[name.Lexeme] = name.Line
+		return declared
+	}
+	if r.WarnShadow && (!parameter || r.ShadowParameters) {
+		if line, ok := r.enclosing(name.Lexeme); ok {
+			r.warn(name, fmt.Sprintf("Variable '%v' shadows a variable declared on line %d.", name.Lexeme, line))
+		}
+	}
+	scope := r.scopes[len(r.scopes)-1]
+	scope.names[name.Lexeme] = declared
+	scope.declared = append(scope.declared, declared)
+	return declared
+}
+
+// lookup finds the local a name refers to, searching outwards from the
+// innermost scope.
+func (r *Resolver) lookup(name string) (*local, bool) {
+	for index := len(r.scopes) - 1; index >= 0; index-- {
+		if local, ok := r.scopes[index].names[name]; ok {
+			return local, true
+		}
+	}
+	return nil, false
+}
+
+// enclosing finds the declaration line of a name in any scope outside the
+// innermost one.
+func (r *Resolver) enclosing(name string) (int, bool) {
+	for index := len(r.scopes) - 2; index >= 0; index-- {
+		if local, ok := r.scopes[index].names[name]; ok {
+			return local.name.Line, true
+		}
+	}
+	if r.ShadowGlobals {
+		if line, ok := r.globals[name]; ok {
+			return line, true
+		}
+	}
+	return 0, false
+}
+
+func (r *Resolver) warn(name token.Token, message string) {
+	r.warnings = append(r.warnings, logger.Warning(name, message))
+}
+
+func (r *Resolver) error(name token.Token, message string) {
+	r.hadError = true
+	r.warnings = append(r.warnings, logger.ResolverError(name, message))
+}
@@ -0,0 +1,214 @@
+package resolver
+
+import (
+	"testing"
+
+	"github.com/lowercasename/golox/ast"
+	"github.com/lowercasename/golox/parser"
+	"github.com/lowercasename/golox/scanner"
+	"github.com/lowercasename/golox/token"
+)
+
+// resolve parses source and resolves it with the given resolver.
+func resolve(r *Resolver, source string) []error {
+	tokens, _ := scanner.Tokenize(source)
+	parser := parser.New(tokens)
+	statements, _ := parser.Parse()
+	return r.Resolve(statements)
+}
+
+func TestWarnShadow(t *testing.T) {
+	r := New()
+	r.WarnShadow = true
+	warnings := resolve(r, "{\n  var a = 1;\n  {\n    var a = 2;\n  }\n}")
+	if len(warnings) != 1 {
+		t.Fatalf("expected=1 warning, got=%q", warnings)
+	}
+	expected := "[line 4] Warning at 'a': Variable 'a' shadows a variable declared on line 2.\n"
+	if warnings[0].Error() != expected {
+		t.Fatalf("expected=%q, got=%q", expected, warnings[0].Error())
+	}
+
+	silent := []string{
+		"{\n  var a = 1;\n  {\n    var b = 2;\n  }\n}",
+		"var a = 1;\n{\n  var a = 2;\n}",
+		"fun f(a) {\n  var a = 1;\n}",
+		"fun f(a) {\n  fun g(a) {}\n}",
+		"{ var _ = 1; { var _ = 2; } }",
+	}
+	for _, source := range silent {
+		if warnings := resolve(New(), source); len(warnings) != 0 {
+			t.Fatalf("expected no warnings when disabled for %q, got=%q", source, warnings)
+		}
+		r := New()
+		r.WarnShadow = true
+		if warnings := resolve(r, source); len(warnings) != 0 {
+			t.Fatalf("expected no warnings for %q, got=%q", source, warnings)
+		}
+	}
+}
+
+func TestWarnShadowConfigured(t *testing.T) {
+	r := New()
+	r.WarnShadow = true
+	r.ShadowGlobals = true
+	if warnings := resolve(r, "var a = 1;\n{\n  var a = 2;\n}"); len(warnings) != 1 {
+		t.Fatalf("expected=1 warning for a shadowed global, got=%q", warnings)
+	}
+
+	r = New()
+	r.WarnShadow = true
+	r.ShadowParameters = true
+	if warnings := resolve(r, "fun f(a) {\n  fun g(a) {}\n}"); len(warnings) != 1 {
+		t.Fatalf("expected=1 warning for a shadowing parameter, got=%q", warnings)
+	}
+}
+
+func TestWarnUnreachable(t *testing.T) {
+	tests := map[string]string{
+		"while (true) {\n  print 1;\n}\nprint 2;\nprint 3;":      "[line 4] Warning at 'print': Unreachable code after the infinite loop on line 1.\n",
+		"fun f() {\n  for (;;) {}\n  var x = 1;\n}":              "[line 3] Warning at 'x': Unreachable code after the infinite loop on line 2.\n",
+		"{\n  while ((true)) {}\n}\nf();":                        "[line 4] Warning at 'f': Unreachable code after the infinite loop on line 2.\n",
+		"if (a) while (true) {} else while (true) {}\n\"s\";":    "[line 1] Warning at 'while': Unreachable code after the infinite loop on line 1.\n",
+		"while (true) {\n  while (true) { break; }\n}\nprint 1;": "[line 4] Warning at 'print': Unreachable code after the infinite loop on line 1.\n",
+	}
+	for source, expected := range tests {
+		r := New()
+		r.WarnUnreachable = true
+		warnings := resolve(r, source)
+		if len(warnings) != 1 || warnings[0].Error() != expected {
+			t.Fatalf("expected=%q for %q, got=%q", expected, source, warnings)
+		}
+	}
+
+	silent := []string{
+		"var a = 1;\nwhile (a < 3) { a = a + 1; }\nprint a;",
+		"while (true) {}",
+		"if (a) while (true) {}\nprint 1;",
+		"while (false) {}\nprint 1;",
+		"while (true) { if (done) break; }\nprint 1;",
+		"for (;;) { { break; } }\nprint 1;",
+	}
+	for _, source := range silent {
+		r := New()
+		r.WarnUnreachable = true
+		if warnings := resolve(r, source); len(warnings) != 0 {
+			t.Fatalf("expected no warnings for %q, got=%q", source, warnings)
+		}
+	}
+	if warnings := resolve(New(), "while (true) {}\nprint 1;"); len(warnings) != 0 {
+		t.Fatalf("expected no warnings when disabled, got=%q", warnings)
+	}
+}
+
+func TestWarnUnused(t *testing.T) {
+	r := New()
+	r.WarnUnused = true
+	warnings := resolve(r, "{\n  var a = 1;\n  var b = 2;\n  b = 3;\n  print 4;\n}")
+	expected := []string{
+		"[line 2] Warning at 'a': Local variable 'a' is never used.\n",
+		"[line 3] Warning at 'b': Local variable 'b' is never used.\n",
+	}
+	if len(warnings) != len(expected) {
+		t.Fatalf("expected=%q, got=%q", expected, warnings)
+	}
+	for n, warning := range warnings {
+		if warning.Error() != expected[n] {
+			t.Fatalf("expected=%q, got=%q", expected[n], warning.Error())
+		}
+	}
+	if r.HadError() {
+		t.Fatalf("expected unused variables to be warnings, not errors")
+	}
+
+	silent := []string{
+		"var a = 1;",
+		"{ var a = 1; print a; }",
+		"{ var a = 1; { print a + 1; } }",
+		"{ var a = [1]; a[0] = 2; }",
+		"{ var a = 1; fun f() { return a; } f(); }",
+		"{ var i = 0; while (i < 3) i = i + 1; }",
+		"fun f(a, b) { return 1; }",
+		"{ var _ = 1; }",
+	}
+	for _, source := range silent {
+		r := New()
+		r.WarnUnused = true
+		if warnings := resolve(r, source); len(warnings) != 0 {
+			t.Fatalf("expected no warnings for %q, got=%q", source, warnings)
+		}
+	}
+	if warnings := resolve(New(), "{ var a = 1; }"); len(warnings) != 0 {
+		t.Fatalf("expected no warnings when disabled, got=%q", warnings)
+	}
+
+	r = New()
+	r.WarnUnused = true
+	r.UnusedErrors = true
+	warnings = resolve(r, "fun f() {\n  var a = 1;\n}")
+	expectedError := "[line 2] ResolverError at 'a': Local variable 'a' is never used.\n"
+	if len(warnings) != 1 || warnings[0].Error() != expectedError {
+		t.Fatalf("expected=%q, got=%q", expectedError, warnings)
+	}
+	if !r.HadError() {
+		t.Fatalf("expected an error in strict mode")
+	}
+}
+
+func TestOwnInitializer(t *testing.T) {
+	r := New()
+	warnings := resolve(r, "var a = 1;\n{\n  var a = a + 1;\n}")
+	expected := "[line 3] ResolverError at 'a': Can't read local variable in its own initializer.\n"
+	if len(warnings) != 1 || warnings[0].Error() != expected {
+		t.Fatalf("expected=%q, got=%q", expected, warnings)
+	}
+	if !r.HadError() {
+		t.Fatalf("expected reading a variable in its own initializer to be an error")
+	}
+
+	allowed := []string{
+		"var a = 1;\nvar a = a + 1;",
+		"{\n  var a = 1;\n  {\n    var b = a;\n  }\n}",
+		"{\n  var a = 1;\n  a = a + 1;\n}",
+	}
+	for _, source := range allowed {
+		r := New()
+		if warnings := resolve(r, source); len(warnings) != 0 || r.HadError() {
+			t.Fatalf("expected no errors for %q, got=%q", source, warnings)
+		}
+	}
+}
+
+func TestReturn(t *testing.T) {
+	r := New()
+	warnings := resolve(r, "class A {\n  init() {\n    return 1;\n  }\n}")
+	expected := "[line 3] ResolverError at 'return': Cannot return a value from an initializer.\n"
+	if len(warnings) != 1 || warnings[0].Error() != expected {
+		t.Fatalf("expected=%q, got=%q", expected, warnings)
+	}
+	if !r.HadError() {
+		t.Fatalf("expected returning a value from an initializer to be an error")
+	}
+
+	allowed := []string{
+		"class A { init() { return; } }",
+		"class A { init() { fun f() { return 1; } } }",
+		"class A { value() { return 1; } }",
+		"fun init() { return 1; }",
+	}
+	for _, source := range allowed {
+		r := New()
+		if warnings := resolve(r, source); len(warnings) != 0 || r.HadError() {
+			t.Fatalf("expected no errors for %q, got=%q", source, warnings)
+		}
+	}
+
+	// The parser rejects a top-level return, so build one by hand.
+	r = New()
+	keyword := token.Token{Type: token.RETURN, Lexeme: "return", Line: 1}
+	warnings = r.Resolve([]ast.Expr{&ast.Return{Keyword: keyword, Value: &ast.Literal{Value: 5.0}}})
+	expected = "[line 1] ResolverError at 'return': Cannot return from top-level code.\n"
+	if len(warnings) != 1 || warnings[0].Error() != expected {
+		t.Fatalf("expected=%q, got=%q", expected, warnings)
+	}
+}
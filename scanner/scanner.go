@@ -1,60 +1,112 @@
 package scanner
 
 import (
-	"fmt"
 	"strconv"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/lowercasename/golox/logger"
 	"github.com/lowercasename/golox/token"
 )
 
 var keywords = map[string]token.Type{
-	"and":    token.AND,
-	"class":  token.CLASS,
-	"else":   token.ELSE,
-	"false":  token.FALSE,
-	"for":    token.FOR,
-	"fun":    token.FUN,
-	"if":     token.IF,
-	"nil":    token.NIL,
-	"or":     token.OR,
-	"print":  token.PRINT,
-	"return": token.RETURN,
-	"super":  token.SUPER,
-	"this":   token.THIS,
-	"true":   token.TRUE,
-	"var":    token.VAR,
-	"while":  token.WHILE,
+	"and":      token.AND,
+	"class":    token.CLASS,
+	"else":     token.ELSE,
+	"false":    token.FALSE,
+	"for":      token.FOR,
+	"fun":      token.FUN,
+	"if":       token.IF,
+	"nil":      token.NIL,
+	"or":       token.OR,
+	"print":    token.PRINT,
+	"return":   token.RETURN,
+	"super":    token.SUPER,
+	"this":     token.THIS,
+	"true":     token.TRUE,
+	"var":      token.VAR,
+	"while":    token.WHILE,
+	"import":   token.IMPORT,
+	"as":       token.AS,
+	"where":    token.WHERE,
+	"defer":    token.DEFER,
+	"enum":     token.ENUM,
+	"const":    token.CONST,
+	"lazy":     token.LAZY,
+	"with":     token.WITH,
+	"break":    token.BREAK,
+	"continue": token.CONTINUE,
+	"unless":   token.UNLESS,
 }
 
+// Keywords returns the reserved words of the language.
+func Keywords() []string {
+	names := make([]string, 0, len(keywords))
+	for name := range keywords {
+		names = append(names, name)
+	}
+	return names
+}
+
+// DefaultTabWidth is the number of columns between tab stops used when
+// reporting columns, unless the scanner is configured otherwise.
+const DefaultTabWidth = 8
+
 type Scanner struct {
 	source  string
 	start   int
 	current int
 	line    int
-	tokens  []token.Token
+	// Offset of the first character of the current line
+	lineStart int
+	// Column at which the current lexeme starts
+	column int
+	tokens []token.Token
+	errors []error
+	// TabWidth is the distance between tab stops when computing columns
+	TabWidth int
 }
 
 // Creates a new scanner
 func New(source string) Scanner {
-	scanner := Scanner{source: source, line: 1, tokens: make([]token.Token, 0)}
+	scanner := Scanner{source: source, line: 1, tokens: make([]token.Token, 0), TabWidth: DefaultTabWidth}
 	return scanner
 }
 
+// Tokenize scans source in one call, returning the tokens alongside any
+// errors encountered while scanning.
+func Tokenize(source string) ([]token.Token, []error) {
+	scanner := New(source)
+	tokens := scanner.ScanTokens()
+	return tokens, scanner.Errors()
+}
+
+// Errors returns the errors collected by the last call to ScanTokens.
+func (scanner *Scanner) Errors() []error {
+	return scanner.errors
+}
+
 func (scanner *Scanner) ScanTokens() []token.Token {
 	for !scanner.isAtEnd() {
 		// We're at the beginning of the next lexeme
 		scanner.start = scanner.current
+		scanner.column = scanner.columnAt(scanner.start)
 		scanner.scanToken()
+		// Every lexeme consumes at least one character. If a malformed token
+		// left the scanner stuck, skip a character so scanning always terminates.
+		if scanner.current <= scanner.start {
+			scanner.error("Scanner made no progress.")
+			scanner.current = scanner.start + 1
+		}
 	}
 	// Add an EOF after all other tokens
-	scanner.tokens = append(scanner.tokens, token.Token{Type: token.EOF, Lexeme: "", Literal: nil, Line: scanner.line})
+	scanner.tokens = append(scanner.tokens, token.Token{Type: token.EOF, Lexeme: "", Literal: nil, Line: scanner.line, Column: scanner.columnAt(scanner.current)})
 	return scanner.tokens
 }
 
 func (scanner *Scanner) addToken(tokenType token.Type, literal any) {
 	text := scanner.source[scanner.start:scanner.current]
-	scanner.tokens = append(scanner.tokens, token.Token{Type: tokenType, Lexeme: text, Literal: literal, Line: scanner.line})
+	scanner.tokens = append(scanner.tokens, token.Token{Type: tokenType, Lexeme: text, Literal: literal, Line: scanner.line, Column: scanner.column})
 }
 
 func (scanner *Scanner) handleIdentifier() {
@@ -76,12 +128,13 @@ func (scanner *Scanner) handleString() {
 	for scanner.peek() != '"' && !scanner.isAtEnd() {
 		if scanner.peek() == '\n' {
 			scanner.line++
+			scanner.lineStart = scanner.current + 1
 		}
 		scanner.current++
 	}
 	// Unterminated string
 	if scanner.isAtEnd() {
-		fmt.Printf(logger.ScannerError(scanner.line, "Unterminated string.").Error())
+		scanner.error("Unterminated string.")
 		return
 	}
 	// Consume the closing "
@@ -91,27 +144,112 @@ func (scanner *Scanner) handleString() {
 	scanner.addToken(token.STRING, stringValue)
 }
 
-func (scanner *Scanner) handleNumber() {
-	for scanner.isDigit(scanner.peek()) {
+func (scanner *Scanner) handleTripleQuotedString() {
+	// Consume the rest of the opening """
+	scanner.current += 2
+	// Keep advancing to the closing """, allowing newlines and lone quotes
+	for !scanner.isAtEnd() && !strings.HasPrefix(scanner.source[scanner.current:], `"""`) {
+		if scanner.peek() == '\n' {
+			scanner.line++
+			scanner.lineStart = scanner.current + 1
+		}
 		scanner.current++
 	}
+	// Unterminated string
+	if scanner.isAtEnd() {
+		scanner.error("Unterminated triple-quoted string.")
+		return
+	}
+	// Consume the closing """
+	scanner.current += 3
+	// Trim the surrounding quotes
+	stringValue := string(scanner.source[scanner.start+3 : scanner.current-3])
+	scanner.addToken(token.STRING, stringValue)
+}
+
+func (scanner *Scanner) handleNumber() {
+	// A hexadecimal integer, like 0xFF
+	if scanner.source[scanner.start] == '0' && (scanner.peek() == 'x' || scanner.peek() == 'X') {
+		scanner.handleHexNumber()
+		return
+	}
+	scanner.consumeDigits(scanner.isDigit)
 	// Look for a fractional part
 	if scanner.peek() == '.' && scanner.isDigit(scanner.peekNext()) {
 		// Consume the "."
 		scanner.current++
-		for scanner.isDigit(scanner.peek()) {
+		scanner.consumeDigits(scanner.isDigit)
+	}
+	// Look for an exponent, like e10 or E-3
+	if scanner.peek() == 'e' || scanner.peek() == 'E' {
+		scanner.current++
+		if scanner.peek() == '+' || scanner.peek() == '-' {
 			scanner.current++
 		}
+		if !scanner.isDigit(scanner.peek()) {
+			scanner.error("Malformed exponent in number literal.")
+			return
+		}
+		scanner.consumeDigits(scanner.isDigit)
+	}
+	numString, ok := scanner.stripSeparators(scanner.source[scanner.start:scanner.current], scanner.isDigit)
+	if !ok {
+		return
 	}
-	numString := string(scanner.source[scanner.start:scanner.current])
 	numValue, err := strconv.ParseFloat(numString, 64)
 	if err != nil {
-		fmt.Printf(logger.ScannerError(scanner.line, "Could not convert number literal to float.").Error())
+		scanner.error("Could not convert number literal to float.")
 		return
 	}
 	scanner.addToken(token.NUMBER, numValue)
 }
 
+func (scanner *Scanner) handleHexNumber() {
+	// Consume the "x"
+	scanner.current++
+	digitsStart := scanner.current
+	scanner.consumeDigits(scanner.isHexDigit)
+	if scanner.current == digitsStart {
+		scanner.error("Expected hexadecimal digits after '0x'.")
+		return
+	}
+	// Check the separators with the "x" in front, so a leading one is caught.
+	digits, ok := scanner.stripSeparators(scanner.source[digitsStart-1:scanner.current], scanner.isHexDigit)
+	if !ok {
+		return
+	}
+	numValue, err := strconv.ParseUint(digits[1:], 16, 64)
+	if err != nil {
+		scanner.error("Hexadecimal literal is too large.")
+		return
+	}
+	scanner.addToken(token.NUMBER, float64(numValue))
+}
+
+// consumeDigits consumes a run of digits, which may be separated by
+// underscores for readability, as in 1_000_000.
+func (scanner *Scanner) consumeDigits(isDigit func(byte) bool) {
+	for isDigit(scanner.peek()) || scanner.peek() == '_' {
+		scanner.current++
+	}
+}
+
+// stripSeparators removes the underscores from a number literal. Each one
+// must sit between two digits; otherwise it reports an error and returns
+// false.
+func (scanner *Scanner) stripSeparators(literal string, isDigit func(byte) bool) (string, bool) {
+	for k := 0; k < len(literal); k++ {
+		if literal[k] != '_' {
+			continue
+		}
+		if k == 0 || k == len(literal)-1 || !isDigit(literal[k-1]) || !isDigit(literal[k+1]) {
+			scanner.error("Digit separators must be placed between digits.")
+			return "", false
+		}
+	}
+	return strings.ReplaceAll(literal, "_", ""), true
+}
+
 func (scanner *Scanner) scanToken() {
 	// Move to the next character (byte) of the source
 	c := scanner.advance()
@@ -125,18 +263,47 @@ func (scanner *Scanner) scanToken() {
 		scanner.addToken(token.LEFT_BRACE, nil)
 	case '}':
 		scanner.addToken(token.RIGHT_BRACE, nil)
+	case '[':
+		scanner.addToken(token.LEFT_BRACKET, nil)
+	case ']':
+		scanner.addToken(token.RIGHT_BRACKET, nil)
 	case ',':
 		scanner.addToken(token.COMMA, nil)
 	case '.':
-		scanner.addToken(token.DOT, nil)
+		if scanner.peek() == '.' && scanner.peekNext() == '.' {
+			scanner.current += 2
+			scanner.addToken(token.ELLIPSIS, nil)
+		} else {
+			scanner.addToken(token.DOT, nil)
+		}
 	case '-':
-		scanner.addToken(token.MINUS, nil)
+		if scanner.match('=') {
+			scanner.addToken(token.MINUS_EQUAL, nil)
+		} else {
+			scanner.addToken(token.MINUS, nil)
+		}
 	case '+':
-		scanner.addToken(token.PLUS, nil)
+		if scanner.match('=') {
+			scanner.addToken(token.PLUS_EQUAL, nil)
+		} else {
+			scanner.addToken(token.PLUS, nil)
+		}
 	case ';':
 		scanner.addToken(token.SEMICOLON, nil)
+	case '?':
+		scanner.addToken(token.QMARK, nil)
+	case ':':
+		if scanner.match('=') {
+			scanner.addToken(token.COLON_EQUAL, nil)
+		} else {
+			scanner.addToken(token.COLON, nil)
+		}
 	case '*':
-		scanner.addToken(token.STAR, nil)
+		if scanner.match('=') {
+			scanner.addToken(token.STAR_EQUAL, nil)
+		} else {
+			scanner.addToken(token.STAR, nil)
+		}
 	case '!':
 		if scanner.match('=') {
 			scanner.addToken(token.BANG_EQUAL, nil)
@@ -174,16 +341,19 @@ func (scanner *Scanner) scanToken() {
 			for !(scanner.peek() == '*' && scanner.peekNext() == '/') && !scanner.isAtEnd() {
 				if scanner.peek() == '\n' {
 					scanner.line++
+					scanner.lineStart = scanner.current + 1
 				}
 				scanner.current++
 			}
 			// Unterminated comment block
 			if scanner.isAtEnd() {
-				fmt.Printf(logger.ScannerError(scanner.line, "Unterminated comment block.").Error())
+				scanner.error("Unterminated comment block.")
 				return
 			}
 			// Consume the closing */
 			scanner.current += 2
+		} else if scanner.match('=') {
+			scanner.addToken(token.SLASH_EQUAL, nil)
 		} else {
 			scanner.addToken(token.SLASH, nil)
 		}
@@ -191,8 +361,13 @@ func (scanner *Scanner) scanToken() {
 		// Ignore whitespace
 	case '\n':
 		scanner.line++
+		scanner.lineStart = scanner.current
 	case '"':
-		scanner.handleString()
+		if scanner.peek() == '"' && scanner.peekNext() == '"' {
+			scanner.handleTripleQuotedString()
+		} else {
+			scanner.handleString()
+		}
 	case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
 		scanner.handleNumber()
 	default:
@@ -200,10 +375,39 @@ func (scanner *Scanner) scanToken() {
 		// character.
 		if scanner.isAlpha(c) {
 			scanner.handleIdentifier()
+		} else if c >= utf8.RuneSelf {
+			// Skip the rest of a multibyte character so it is reported once,
+			// not once per byte.
+			_, size := utf8.DecodeRuneInString(scanner.source[scanner.start:])
+			scanner.current = scanner.start + size
+			scanner.error("Non-ASCII character in source.")
+		} else {
+			scanner.error("Unexpected character.")
+		}
+	}
+}
+
+// columnAt returns the 1-based column of offset on the current line, with
+// each tab advancing to the next tab stop.
+func (scanner *Scanner) columnAt(offset int) int {
+	width := scanner.TabWidth
+	if width < 1 {
+		width = DefaultTabWidth
+	}
+	column := 1
+	for _, c := range []byte(scanner.source[scanner.lineStart:offset]) {
+		if c == '\t' {
+			column = ((column-1)/width+1)*width + 1
 		} else {
-			fmt.Printf(logger.ScannerError(scanner.line, "Unexpected charater.").Error())
+			column++
 		}
 	}
+	return column
+}
+
+// error records a scanner error on the current line
+func (scanner *Scanner) error(message string) {
+	scanner.errors = append(scanner.errors, logger.ScannerError(scanner.line, message))
 }
 
 func (scanner *Scanner) isAtEnd() bool {
@@ -214,6 +418,10 @@ func (scanner *Scanner) isDigit(b byte) bool {
 	return b >= 0x30 && b <= 0x39
 }
 
+func (scanner *Scanner) isHexDigit(b byte) bool {
+	return scanner.isDigit(b) || (b >= 'a' && b <= 'f') || (b >= 'A' && b <= 'F')
+}
+
 func (scanner *Scanner) isAlpha(b byte) bool {
 	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || b == '_'
 }
@@ -0,0 +1,221 @@
+package scanner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lowercasename/golox/token"
+)
+
+func TestTokenize(t *testing.T) {
+	tokens, errors := Tokenize("var a = 1;")
+	if len(errors) != 0 {
+		t.Fatalf("expected no errors, got=%q", errors)
+	}
+	expected := []token.Type{token.VAR, token.IDENTIFIER, token.EQUAL, token.NUMBER, token.SEMICOLON, token.EOF}
+	if len(tokens) != len(expected) {
+		t.Fatalf("expected=%d tokens, got=%d", len(expected), len(tokens))
+	}
+	for i, tok := range tokens {
+		if tok.Type != expected[i] {
+			t.Fatalf("expected=%q, got=%q", expected[i], tok.Type)
+		}
+	}
+
+	tokens, errors = Tokenize("var s = \"unterminated;")
+	if len(errors) != 1 {
+		t.Fatalf("expected=1 error, got=%d", len(errors))
+	}
+	if errors[0].Error() != "[line 1] ScannerError: Unterminated string.\n" {
+		t.Fatalf("expected=unterminated string error, got=%q", errors[0].Error())
+	}
+	if tokens[len(tokens)-1].Type != token.EOF {
+		t.Fatalf("expected tokens to end with EOF, got=%q", tokens[len(tokens)-1].Type)
+	}
+}
+
+func TestEllipsis(t *testing.T) {
+	tokens, errors := Tokenize("f(...a.b)")
+	if len(errors) != 0 {
+		t.Fatalf("expected no errors, got=%q", errors)
+	}
+	expected := []token.Type{token.IDENTIFIER, token.LEFT_PAREN, token.ELLIPSIS, token.IDENTIFIER, token.DOT, token.IDENTIFIER, token.RIGHT_PAREN, token.EOF}
+	if len(tokens) != len(expected) {
+		t.Fatalf("expected=%d tokens, got=%d", len(expected), len(tokens))
+	}
+	for i, tok := range tokens {
+		if tok.Type != expected[i] {
+			t.Fatalf("expected=%q, got=%q", expected[i], tok.Type)
+		}
+	}
+}
+
+func TestScanTokensTerminates(t *testing.T) {
+	inputs := []string{
+		"\"",
+		"/*",
+		"/* *",
+		"/*/",
+		"1.",
+		"1..2",
+		"#@$~`",
+		"\"abc\n\ndef",
+		"// comment without newline",
+		"!=<=>===!",
+	}
+	for _, input := range inputs {
+		done := make(chan []token.Token)
+		go func(source string) {
+			tokens, _ := Tokenize(source)
+			done <- tokens
+		}(input)
+		select {
+		case tokens := <-done:
+			if tokens[len(tokens)-1].Type != token.EOF {
+				t.Fatalf("expected tokens for %q to end with EOF, got=%q", input, tokens[len(tokens)-1].Type)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("scanner did not terminate on %q", input)
+		}
+	}
+}
+
+func TestColumns(t *testing.T) {
+	tests := []struct {
+		tabWidth int
+		source   string
+		expected int
+	}{
+		{8, "\tfoo", 9},
+		{4, "\tfoo", 5},
+		{2, "\tfoo", 3},
+		{4, "a\tfoo", 5},
+		{4, "abcd\tfoo", 9},
+		{8, "a \tfoo", 9},
+		{4, "\n\t\tfoo", 9},
+		{4, "/*\n*/\tfoo", 5},
+	}
+	for _, tt := range tests {
+		scanner := New(tt.source)
+		scanner.TabWidth = tt.tabWidth
+		tokens := scanner.ScanTokens()
+		foo := tokens[len(tokens)-2]
+		if foo.Lexeme != "foo" || foo.Column != tt.expected {
+			t.Fatalf("expected column=%d for %q with tab width %d, got=%d", tt.expected, tt.source, tt.tabWidth, foo.Column)
+		}
+	}
+	tokens, _ := Tokenize("var a = 1;")
+	if tokens[1].Column != 5 || tokens[4].Column != 10 {
+		t.Fatalf("expected columns 5 and 10, got=%d and %d", tokens[1].Column, tokens[4].Column)
+	}
+}
+
+func TestTripleQuotedString(t *testing.T) {
+	tokens, errors := Tokenize("var s = \"\"\"first \"line\"\nsecond line\"\"\";\nprint s;")
+	if len(errors) != 0 {
+		t.Fatalf("expected no errors, got=%q", errors)
+	}
+	s := tokens[3]
+	if s.Type != token.STRING || s.Literal != "first \"line\"\nsecond line" {
+		t.Fatalf("expected a two-line string, got=%q %q", s.Type, s.Literal)
+	}
+	if tokens[5].Type != token.PRINT || tokens[5].Line != 3 {
+		t.Fatalf("expected print on line 3, got=%q on line %d", tokens[5].Type, tokens[5].Line)
+	}
+
+	tokens, _ = Tokenize("\"\" \"\"\"\"\"\"")
+	if len(tokens) != 3 || tokens[0].Literal != "" || tokens[1].Literal != "" {
+		t.Fatalf("expected two empty strings, got=%v", tokens)
+	}
+
+	tokens, errors = Tokenize("\"\"\"never\nclosed\"\"")
+	if len(errors) != 1 || errors[0].Error() != "[line 2] ScannerError: Unterminated triple-quoted string.\n" {
+		t.Fatalf("expected an unterminated string error, got=%q", errors)
+	}
+	if tokens[len(tokens)-1].Type != token.EOF {
+		t.Fatalf("expected tokens to end with EOF, got=%q", tokens[len(tokens)-1].Type)
+	}
+}
+
+func TestNonASCII(t *testing.T) {
+	tests := map[string]int{
+		"var é = 1;":       1,
+		"print 1; → 2;":    1,
+		"var a = 😀;":       1,
+		"ü ö":              2,
+		"print \"héllo\";": 0,
+		"// ünïcode":       0,
+	}
+	for source, expected := range tests {
+		_, errors := Tokenize(source)
+		if len(errors) != expected {
+			t.Fatalf("expected=%d errors for %q, got=%q", expected, source, errors)
+		}
+		if expected > 0 && errors[0].Error() != "[line 1] ScannerError: Non-ASCII character in source.\n" {
+			t.Fatalf("expected a non-ASCII error, got=%q", errors[0].Error())
+		}
+	}
+}
+
+func TestNumberLiterals(t *testing.T) {
+	tests := map[string]float64{
+		"1e3":       1000,
+		"1e10":      1e10,
+		"2.5e-3":    0.0025,
+		"6.02E23":   6.02e23,
+		"1e+2":      100,
+		"0x10":      16,
+		"0xFF":      255,
+		"0Xff":      255,
+		"0":         0,
+		"1_000":     1000,
+		"1_000_000": 1000000,
+		"3.141_592": 3.141592,
+		"1_0e1_0":   1e11,
+		"0xFF_FF":   65535,
+	}
+	for source, expected := range tests {
+		tokens, errors := Tokenize(source)
+		if len(errors) != 0 || len(tokens) != 2 || tokens[0].Literal != expected {
+			t.Fatalf("expected=%v for %s, got=%v (%q)", expected, source, tokens, errors)
+		}
+	}
+
+	errors := map[string]string{
+		"\n1e;":                "[line 2] ScannerError: Malformed exponent in number literal.\n",
+		"2.5e-":                "[line 1] ScannerError: Malformed exponent in number literal.\n",
+		"0x;":                  "[line 1] ScannerError: Expected hexadecimal digits after '0x'.\n",
+		"0x10000000000000000;": "[line 1] ScannerError: Hexadecimal literal is too large.\n",
+		"1__0":                 "[line 1] ScannerError: Digit separators must be placed between digits.\n",
+		"100_":                 "[line 1] ScannerError: Digit separators must be placed between digits.\n",
+		"1_.5":                 "[line 1] ScannerError: Digit separators must be placed between digits.\n",
+		"0x_F":                 "[line 1] ScannerError: Digit separators must be placed between digits.\n",
+	}
+	for source, expected := range errors {
+		_, errs := Tokenize(source)
+		if len(errs) != 1 || errs[0].Error() != expected {
+			t.Fatalf("expected=%q for %q, got=%q", expected, source, errs)
+		}
+	}
+	// A leading underscore starts an identifier rather than a number.
+	tokens, errs := Tokenize("_100")
+	if len(errs) != 0 || tokens[0].Type != token.IDENTIFIER {
+		t.Fatalf("expected an identifier, got=%v (%q)", tokens, errs)
+	}
+}
+
+func TestCompoundOperators(t *testing.T) {
+	tokens, errors := Tokenize("+= -= *= /= + / // comment")
+	if len(errors) != 0 {
+		t.Fatalf("expected no errors, got=%q", errors)
+	}
+	expected := []token.Type{token.PLUS_EQUAL, token.MINUS_EQUAL, token.STAR_EQUAL, token.SLASH_EQUAL, token.PLUS, token.SLASH, token.EOF}
+	if len(tokens) != len(expected) {
+		t.Fatalf("expected=%d tokens, got=%d", len(expected), len(tokens))
+	}
+	for i, tok := range tokens {
+		if tok.Type != expected[i] {
+			t.Fatalf("expected=%q, got=%q", expected[i], tok.Type)
+		}
+	}
+}
@@ -6,19 +6,21 @@ type Type string
 
 const (
 	// single-character tokens
-	LEFT_PAREN  = "("
-	RIGHT_PAREN = ")"
-	LEFT_BRACE  = "{"
-	RIGHT_BRACE = "}"
-	COMMA       = ","
-	DOT         = "."
-	MINUS       = "-"
-	PLUS        = "+"
-	SEMICOLON   = ";"
-	SLASH       = "/"
-	STAR        = "*"
-	QMARK       = "?"
-	COLON       = ":"
+	LEFT_PAREN    = "("
+	RIGHT_PAREN   = ")"
+	LEFT_BRACE    = "{"
+	RIGHT_BRACE   = "}"
+	LEFT_BRACKET  = "["
+	RIGHT_BRACKET = "]"
+	COMMA         = ","
+	DOT           = "."
+	MINUS         = "-"
+	PLUS          = "+"
+	SEMICOLON     = ";"
+	SLASH         = "/"
+	STAR          = "*"
+	QMARK         = "?"
+	COLON         = ":"
 	// one or two character tokens
 	BANG          = "!"
 	BANG_EQUAL    = "!="
@@ -28,29 +30,46 @@ const (
 	GREATER_EQUAL = ">="
 	LESS          = "<"
 	LESS_EQUAL    = "<="
+	COLON_EQUAL   = ":="
+	PLUS_EQUAL    = "+="
+	MINUS_EQUAL   = "-="
+	STAR_EQUAL    = "*="
+	SLASH_EQUAL   = "/="
+	ELLIPSIS      = "..."
 	// literals
 	IDENTIFIER = "IDENTIFIER"
 	STRING     = "STRING"
 	NUMBER     = "NUMBER"
 	// keywords
-	AND     = "and"
-	CLASS   = "class"
-	ELSE    = "else"
-	FALSE   = "false"
-	FUN     = "fun"
-	FOR     = "for"
-	IF      = "if"
-	NIL     = "nil"
-	OR      = "or"
-	PRINT   = "print"
-	RETURN  = "return"
-	SUPER   = "super"
-	THIS    = "this"
-	TRUE    = "true"
-	VAR     = "var"
-	WHILE   = "while"
-	EOF     = "EOF"
-	INVALID = "__INVALID__"
+	AND      = "and"
+	CLASS    = "class"
+	ELSE     = "else"
+	FALSE    = "false"
+	FUN      = "fun"
+	FOR      = "for"
+	IF       = "if"
+	NIL      = "nil"
+	OR       = "or"
+	PRINT    = "print"
+	RETURN   = "return"
+	SUPER    = "super"
+	THIS     = "this"
+	TRUE     = "true"
+	VAR      = "var"
+	WHILE    = "while"
+	IMPORT   = "import"
+	AS       = "as"
+	WHERE    = "where"
+	DEFER    = "defer"
+	ENUM     = "enum"
+	CONST    = "const"
+	LAZY     = "lazy"
+	WITH     = "with"
+	BREAK    = "break"
+	CONTINUE = "continue"
+	UNLESS   = "unless"
+	EOF      = "EOF"
+	INVALID  = "__INVALID__"
 )
 
 type Token struct {
@@ -58,6 +77,8 @@ type Token struct {
 	Lexeme  string
 	Literal any
 	Line    int
+	// Column is the 1-based column at which the lexeme starts
+	Column int
 }
 
 func (token *Token) String() string {